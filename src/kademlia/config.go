@@ -0,0 +1,133 @@
+package main
+
+// Node options come from the -config file, then the flags below, then the
+// positional arguments of the old two-argument form.
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+import (
+	"libkademlia"
+)
+
+type configFlags struct {
+	file       string
+	listen     string
+	advertise  string
+	nodeID     string
+	bootstrap  string
+	retry      time.Duration
+	maxRetry   time.Duration
+	bucketSize int
+	alpha      int
+	rpcTimeout time.Duration
+	storeTTL   time.Duration
+	dataDir    string
+	logFile    string
+	logLevel   string
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.file, "config", "", "read node options from JSON `file`")
+	fs.StringVar(&f.listen, "listen", "", "serve RPCs on `host:port`, port 0 picks a free one")
+	fs.StringVar(&f.advertise, "advertise", "", "tell other nodes to reach us at `host[:port]` (default the listen address)")
+	fs.StringVar(&f.nodeID, "id", "", "node `ID` in hex (default random)")
+	fs.StringVar(&f.bootstrap, "bootstrap", "", "comma separated `peers` (host:port, srv:name or txt:name) to join through")
+	fs.DurationVar(&f.retry, "bootstrap-retry", 0, "first wait before retrying to join, 0 tries once (default 1s)")
+	fs.DurationVar(&f.maxRetry, "bootstrap-max-retry", 0, "longest wait between join attempts (default 1m)")
+	fs.IntVar(&f.bucketSize, "k", 0, "bucket `size` (default 20)")
+	fs.IntVar(&f.alpha, "alpha", 0, "parallel RPCs per lookup `step` (default 3)")
+	fs.DurationVar(&f.rpcTimeout, "rpc-timeout", 0, "give up on an RPC after `duration` (default 10s)")
+	fs.DurationVar(&f.storeTTL, "store-ttl", 0, "expire stored values after `duration` (default never)")
+	fs.StringVar(&f.dataDir, "data-dir", "", "save values and VDOs in `dir` across restarts")
+	fs.StringVar(&f.logFile, "log", "", "log to `file` (default stderr, kademlia.log with -daemon)")
+	fs.StringVar(&f.logLevel, "log-level", "", "log at `level` debug, info, warn or error and above (default info)")
+}
+
+// config builds the node options from the config file, the flags that were
+// set and the positional arguments
+func (f *configFlags) config(fs *flag.FlagSet) (libkademlia.Config, error) {
+	cfg := libkademlia.DefaultConfig()
+	if f.file != "" {
+		var err error
+		if cfg, err = libkademlia.LoadConfig(f.file); err != nil {
+			return cfg, err
+		}
+	}
+
+	var errs []error
+	fs.Visit(func(fl *flag.Flag) {
+		var err error
+		switch fl.Name {
+		case "listen":
+			cfg.Listen = f.listen
+		case "advertise":
+			cfg.Advertise = f.advertise
+		case "id":
+			cfg.NodeID, err = libkademlia.IDFromString(f.nodeID)
+		case "bootstrap":
+			cfg.Bootstrap = splitList(f.bootstrap)
+		case "bootstrap-retry":
+			cfg.BootstrapRetry = f.retry
+		case "bootstrap-max-retry":
+			cfg.BootstrapMaxRetry = f.maxRetry
+		case "k":
+			cfg.BucketSize = f.bucketSize
+		case "alpha":
+			cfg.Alpha = f.alpha
+		case "rpc-timeout":
+			cfg.RPCTimeout = f.rpcTimeout
+		case "store-ttl":
+			cfg.StoreTTL = f.storeTTL
+		case "data-dir":
+			cfg.DataDir = f.dataDir
+		case "log":
+			cfg.LogFile = f.logFile
+		case "log-level":
+			cfg.LogLevel, err = libkademlia.ParseLevel(f.logLevel)
+		}
+		errs = append(errs, err)
+	})
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		cfg.Listen = fs.Arg(0)
+		cfg.Bootstrap = []string{fs.Arg(1)}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	return cfg, cfg.Validate()
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// logTo sends this program's own log to the node's log file too
+func logTo(file string) {
+	if file == "" {
+		return
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(f)
+}
@@ -0,0 +1,200 @@
+package main
+
+// The control socket lets other processes run commands on a long-running
+// node. Each connection carries one request and one response, both JSON on a
+// single line, so any number of clients can talk to the node at once.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+import (
+	"libkademlia"
+)
+
+// Set in the environment of the detached child
+const detachedEnv = "KADEMLIA_DETACHED"
+
+type controlRequest struct {
+	Line string
+	JSON bool
+}
+
+type controlResponse struct {
+	Output string
+	Failed bool
+}
+
+func defaultSocket() string {
+	return filepath.Join(os.TempDir(), "kademlia.sock")
+}
+
+func detached() bool {
+	return os.Getenv(detachedEnv) != ""
+}
+
+// detach starts this program again in its own session with stdin closed and
+// output going to logFile, waits for its control socket to come up, and
+// returns the exit status for the parent
+func detach(socket string, logFile string) int {
+	out, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer out.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), detachedEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			log.Printf("Daemon exited: %v, see %s\n", err, logFile)
+			return 1
+		case <-time.After(100 * time.Millisecond):
+		}
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			fmt.Printf("kademlia running as pid %d, control socket %s\n", cmd.Process.Pid, socket)
+			return 0
+		}
+	}
+	log.Printf("Daemon didn't open %s, see %s\n", socket, logFile)
+	return 1
+}
+
+func waitForSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	log.Printf("Got %v, shutting down\n", sig)
+}
+
+// listenControl opens the control socket, taking over a stale socket file left
+// by a node that died but refusing to share one with a live node
+func listenControl(socket string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, errors.New("another node is serving " + socket)
+	}
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	// Only the owner may drive the node
+	os.Chmod(socket, 0600)
+	return l, nil
+}
+
+func serveControl(k *libkademlia.Kademlia, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		go handleControl(k, conn)
+	}
+}
+
+func handleControl(k *libkademlia.Kademlia, conn net.Conn) {
+	defer conn.Close()
+	var req controlRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		// A probe for whether the socket is up
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		log.Printf("Bad control request: %s\n", err)
+		return
+	}
+
+	var resp controlResponse
+	cmd := strings.TrimSpace(req.Line)
+	var text string
+	var res commandResult
+	start := time.Now()
+	if strings.Fields(cmd) == nil {
+		text = "ERR: Empty command"
+	} else if f := strings.Fields(cmd)[0]; f == "quit" || f == "exit" {
+		// Other clients may still be using the node
+		text = "ERR: Stop the daemon with SIGTERM instead"
+	} else {
+		text = executeLine(k, cmd, &res)
+	}
+	var buf bytes.Buffer
+	writeResponse(&buf, cmd, text, &res, time.Since(start), req.JSON)
+	resp.Output = buf.String()
+	resp.Failed = commandFailed(text)
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// ctl sends one command to a daemon and prints its response, returning the
+// exit status
+func ctl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultSocket(), "control `socket` of the node")
+	asJSON := fs.Bool("json", false, "print the response as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kademlia ctl [-socket path] [-json] command [args...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERR: %s\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	req := controlRequest{strings.Join(fs.Args(), " "), *asJSON}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		fmt.Fprintf(os.Stderr, "ERR: %s\n", err)
+		return 1
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		fmt.Fprintf(os.Stderr, "ERR: %s\n", err)
+		return 1
+	}
+	fmt.Print(resp.Output)
+	if resp.Failed {
+		return 1
+	}
+	return 0
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
@@ -31,58 +37,232 @@ func main() {
 	// random numbers
 	rand.Seed(time.Now().UnixNano())
 
+	// kademlia ctl <command> talks to a running daemon
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl(os.Args[2:]))
+	}
+
 	// Get the bind and connect connection strings from command-line arguments.
+	var commands commandList
+	flag.Var(&commands, "c", "run `command` and exit, may be repeated")
+	script := flag.String("f", "", "read commands from `file` (- for stdin) and exit")
+	quiet := flag.Bool("q", false, "don't print the prompt")
+	flag.BoolVar(&jsonOutput, "json", false, "print one JSON object per command")
+	daemon := flag.Bool("daemon", false, "run in the background, taking commands on the control socket")
+	socket := flag.String("socket", "", "serve commands on this unix `socket` (default "+defaultSocket()+" with -daemon)")
+	var options configFlags
+	options.register(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kademlia [options] [listen-address first-peer]")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	args := flag.Args()
-	if len(args) != 2 {
-		log.Fatal("Must be invoked with exactly two arguments!\n")
+	cfg, err := options.config(flag.CommandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *daemon && *socket == "" {
+		*socket = defaultSocket()
 	}
-	listenStr := args[0]
-	firstPeerStr := args[1]
+	if *daemon && !detached() {
+		logFile := cfg.LogFile
+		if logFile == "" {
+			logFile = "kademlia.log"
+		}
+		os.Exit(detach(*socket, logFile))
+	}
+	logTo(cfg.LogFile)
 
 	// Create the Kademlia instance
 	log.Println("Kademlia starting up!")
 	log.Println("Group: " + netIds + "\n")
 
-	kadem := libkademlia.NewKademlia(listenStr)
+	kadem, err := libkademlia.NewKademliaFromConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Join through the bootstrap peers, retrying in the background if none
+	// answers. See README.txt for more details.
+	log.Printf("Pinging initial peers\n")
+	log.Println(joinStatus(kadem.Bootstrap(), nil))
+
+	if *socket != "" {
+		l, err := listenControl(*socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(*socket)
+		go serveControl(kadem, l)
+		log.Printf("Control socket: %s\n", *socket)
+	}
+
+	// Scripts run to the end, or the first quit, and exit with status 1 if
+	// any command failed
+	var failures int
+	switch {
+	case *daemon:
+		waitForSignal()
+	case len(commands) > 0:
+		failures, _ = runCommands(kadem, strings.NewReader(strings.Join(commands, "\n")), false)
+	case *script == "-":
+		failures, _ = runCommands(kadem, os.Stdin, false)
+	case *script != "":
+		f, err := os.Open(*script)
+		if err != nil {
+			log.Fatal(err)
+		}
+		failures, _ = runCommands(kadem, f, false)
+		f.Close()
+	default:
+		runCommands(kadem, os.Stdin, !*quiet)
+	}
+	// Give RPCs in flight a moment, but don't hang on a peer that never
+	// hangs up
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := kadem.Close(ctx); err != nil {
+		log.Println(err)
+	}
+	cancel()
+	if failures > 0 {
+		os.Remove(*socket)
+		os.Exit(1)
+	}
+}
+
+// commandList collects repeated -c flags
+type commandList []string
 
-	// Confirm our server is up with a PING request and then exit.
-	// Your code should loop forever, reading instructions from stdin and
-	// printing their results to stdout. See README.txt for more details.
-	ip, port, _ := StringToIpPort(firstPeerStr)
+func (c *commandList) String() string {
+	return strings.Join(*c, "; ")
+}
+
+func (c *commandList) Set(cmd string) error {
+	*c = append(*c, cmd)
+	return nil
+}
+
+// Set by -json
+var jsonOutput bool
 
-	log.Printf("Pinging initial peer\n")
+// commandResult is the structured form of a command's response, printed
+// with -json
+type commandResult struct {
+	Command   string                   `json:"command"`
+	Status    string                   `json:"status"`
+	Error     string                   `json:"error,omitempty"`
+	Message   string                   `json:"message,omitempty"`
+	NodeID    string                   `json:"node_id,omitempty"`
+	Contacts  []contactInfo            `json:"contacts,omitempty"`
+	Value     []byte                   `json:"value,omitempty"`
+	Trace     *libkademlia.LookupTrace `json:"trace,omitempty"`
+	ElapsedMs float64                  `json:"elapsed_ms"`
+}
+
+type contactInfo struct {
+	ID       string   `json:"id"`
+	Host     string   `json:"host"`
+	AltHosts []string `json:"alt_hosts,omitempty"`
+	Port     uint16   `json:"port"`
+}
 
-	firstpeer, err := kadem.DoPing(ip, uint16(port))
+func (res *commandResult) addContacts(contacts ...libkademlia.Contact) {
+	for _, c := range contacts {
+		var alt []string
+		for _, ip := range c.AltHosts {
+			alt = append(alt, ip.String())
+		}
+		res.Contacts = append(res.Contacts, contactInfo{c.NodeID.AsString(), c.Host.String(), alt, c.Port})
+	}
+}
+
+// contactIDs formats contacts the way the README asks: a slice of IDs
+func contactIDs(contacts []libkademlia.Contact) string {
+	ids := make([]string, len(contacts))
+	for i, c := range contacts {
+		ids[i] = c.NodeID.AsString()
+	}
+	return fmt.Sprintf("%v", ids)
+}
+
+// printResponse prints a response as text, or as JSON with -json
+func printResponse(line string, resp string, res *commandResult, elapsed time.Duration) {
+	writeResponse(os.Stdout, line, resp, res, elapsed, jsonOutput)
+}
+
+func writeResponse(w io.Writer, line string, resp string, res *commandResult, elapsed time.Duration, asJSON bool) {
+	if !asJSON {
+		fmt.Fprintf(w, "%v\n", resp)
+		return
+	}
+	res.Command = strings.Fields(line)[0]
+	res.ElapsedMs = float64(elapsed) / float64(time.Millisecond)
+	switch {
+	case strings.HasPrefix(resp, "ERR"):
+		res.Status = "error"
+		res.Error = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(resp, "ERR"), ":"))
+	case strings.HasPrefix(resp, "usage:"):
+		res.Status = "error"
+		res.Error = resp
+	default:
+		res.Status = "ok"
+		res.Message = resp
+	}
+	out, err := json.Marshal(res)
 	if err != nil {
-		log.Printf("Can't ping initial peer: %s\n", err.Error())
-	} else {
-		log.Printf("Initial peer: %s\n", (*firstpeer).NodeID.AsString())
+		log.Println(err)
+		return
 	}
+	fmt.Fprintf(w, "%s\n", out)
+}
 
-	in := bufio.NewReader(os.Stdin)
-	quit := false
+// runCommands executes one command per line until EOF or quit, returning how
+// many commands failed
+func runCommands(k *libkademlia.Kademlia, r io.Reader, prompt bool) (failures int, quit bool) {
+	in := bufio.NewReader(r)
 	for !quit {
-		fmt.Printf("kademlia> ")
+		if prompt && !jsonOutput {
+			fmt.Printf("kademlia> ")
+		}
 		line, err := in.ReadString('\n')
-		if err != nil {
-			log.Fatal(err)
+		if err != nil && err != io.EOF {
+			log.Println(err)
+			failures++
+			return
 		}
+		eof := err == io.EOF
 		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		if line != "" && !strings.HasPrefix(line, "#") {
+			var res commandResult
+			start := time.Now()
+			resp := executeLine(k, line, &res)
+			if resp == "quit" {
+				quit = true
+			} else if resp != "" {
+				printResponse(line, resp, &res, time.Since(start))
+			}
+			if commandFailed(resp) {
+				failures++
+			}
 		}
-		resp := executeLine(kadem, line)
-		if resp == "quit" {
-			quit = true
-			kadem.Finalize()
-		} else if resp != "" {
-			fmt.Printf("%v\n", resp)
+		if eof {
+			if prompt && !jsonOutput {
+				fmt.Println()
+			}
+			return
 		}
 	}
+	return
 }
 
-func executeLine(k *libkademlia.Kademlia, line string) (response string) {
+// commandFailed tells from executeLine's response whether the command failed
+func commandFailed(resp string) bool {
+	return strings.HasPrefix(resp, "ERR") || strings.HasPrefix(resp, "usage:")
+}
+
+// executeLine runs one command and returns its text response. Contacts, values
+// and IDs it produces are also filled into res for -json.
+func executeLine(k *libkademlia.Kademlia, line string, res *commandResult) (response string) {
 	toks := strings.Fields(line)
 	switch {
 	case toks[0] == "quit":
@@ -97,6 +277,7 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 			return
 		}
 		response = k.NodeID.AsString()
+		res.NodeID = response
 
 	case toks[0] == "print_contact":
 		if len(toks) < 2 || len(toks) > 2 {
@@ -113,8 +294,12 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 			response = "ERR: Unknown contact node ID"
 			return
 		}
+		res.addContacts(*c)
 		response = "OK: NodeID=" + toks[1] + "\n"
 		response += "      Host=" + c.Host.String() + "\n"
+		for _, ip := range c.AltHosts {
+			response += "      Host=" + ip.String() + "\n"
+		}
 		response += "      Port=" + strconv.Itoa(int(c.Port))
 
 	case toks[0] == "ping":
@@ -138,29 +323,21 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 				response = "ERR: Not a valid Node ID or host:port address"
 				return
 			}
-			port, err := strconv.Atoi(portstr)
-			if err != nil {
+			if _, err := strconv.ParseUint(portstr, 10, 16); err != nil || hostname == "" {
 				response = "ERR: Not a valid Node ID or host:port address"
 				return
 			}
-			ipAddrStrings, err := net.LookupHost(hostname)
-			if err != nil {
+			// Every address of the host, IPv4 or IPv6, until one answers
+			contact, err = k.DoPingAddr(toks[1])
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) {
 				response = "ERR: Could not find the provided hostname"
 				return
-			}
-			var host net.IP
-			for i := 0; i < len(ipAddrStrings); i++ {
-				host = net.ParseIP(ipAddrStrings[i])
-				if host.To4() != nil {
-					break
-				}
-			}
-			fmt.Println(host, port)
-			contact, err = k.DoPing(host, uint16(port))
-			if err != nil {
+			} else if err != nil {
 				response = fmt.Sprintf("ERR: %s", err)
 				return
 			} else {
+				res.addContacts(*contact)
 				response = "OK: " + contact.NodeID.AsString()
 				return
 			}
@@ -170,10 +347,11 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 				response = "ERR: Not a valid Node ID or host:port address"
 				return
 			}
-			contact, err = k.DoPing(c.Host, c.Port)
+			contact, err = k.DoPingContact(c)
 			if err != nil {
 				response = fmt.Sprintf("ERR: %s", err)
 			} else {
+				res.addContacts(*contact)
 				response = "OK: " + contact.NodeID.AsString()
 			}
 		}
@@ -193,6 +371,7 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else {
+			res.Value = result
 			response = "OK: " + string(result)
 		}
 
@@ -223,6 +402,7 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else {
+			res.addContacts(*contact)
 			response = fmt.Sprintf("OK: %s stored by contact %s at key %s",
 				string(value), nodeId.AsString(), key.AsString())
 		}
@@ -253,7 +433,8 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else {
-			response = fmt.Sprintf("Ok: Got %d contacts", len(contacts))
+			res.addContacts(contacts...)
+			response = fmt.Sprintf("OK: Got %d contacts\n%s", len(contacts), contactIDs(contacts))
 		}
 
 	case toks[0] == "find_value":
@@ -282,9 +463,11 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else if value != nil {
+			res.Value = value
 			response = fmt.Sprintf("OK: Found %s", value)
 		} else {
-			response = fmt.Sprintf("OK: Got %d contacts", len(contacts))
+			res.addContacts(contacts...)
+			response = fmt.Sprintf("OK: Got %d contacts\n%s", len(contacts), contactIDs(contacts))
 		}
 
 	case toks[0] == "iterativeFindNode":
@@ -302,7 +485,8 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else {
-			response = fmt.Sprintf("OK: Got %d contacts", len(contacts))
+			res.addContacts(contacts...)
+			response = fmt.Sprintf("OK: Got %d contacts\n%s", len(contacts), contactIDs(contacts))
 		}
 
 	case toks[0] == "iterativeStore":
@@ -320,6 +504,7 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else {
+			res.addContacts(contacts...)
 			response = fmt.Sprintf("OK: Stored value on %d contacts", len(contacts))
 		}
 
@@ -338,73 +523,204 @@ func executeLine(k *libkademlia.Kademlia, line string) (response string) {
 		if err != nil {
 			response = fmt.Sprintf("ERR: %s", err)
 		} else {
+			res.Value = value
 			response = fmt.Sprintf("OK: Found value %s", value)
 		}
 	case toks[0] == "vanish":
-		if len(toks) < 5 || len(toks) > 5 {
-			response = "usage: vanish [VDO ID] [data] [numberKeys] [threshold]"
+		if len(toks) < 5 || len(toks) > 6 {
+			response = "usage: vanish [VDO ID] [data] [numberKeys] [threshold] [timeout (optional)]"
 			return
 		}
-		key, err := kademlia.IDFromString(toks[1])
+		key, err := libkademlia.IDFromString(toks[1])
 		if err != nil {
 			response = "ERR: Provided an invalid VDO ID (" + toks[1] + ")"
 			return
 		}
-		numberKeys, err := strconv.ParseInt(toks[3], 10, 0)
+		numberKeys, err := strconv.ParseUint(toks[3], 10, 8)
 		if err != nil {
 			response = "ERR: Provided an invalid numberKeys (" + toks[3] + ")"
 			return
 		}
-		threshold, err := strconv.ParseInt(toks[4], 10, 0)
+		threshold, err := strconv.ParseUint(toks[4], 10, 8)
 		if err != nil {
 			response = "ERR: Provided an invalid threshold (" + toks[4] + ")"
 			return
 		}
-		// timeout, err := strconv.ParseInt(toks[5], 10, 0)
-		// if err != nil {
-		// 	response = "ERR: Provided an invalid timeout (" + toks[5] + ")"
-		// 	return
-		// }
-		vanishObject := k.Vanish(key, []byte(toks[2]), numberKeys, threshold, 0)
-	case toks[0] == "unvanish":
-		if len(toks) != 3 {
-			response = "usage: unvanish [Node ID] [VDO ID]"
+		timeout := 0
+		if len(toks) == 6 {
+			timeout, err = strconv.Atoi(toks[5])
+			if err != nil {
+				response = "ERR: Provided an invalid timeout (" + toks[5] + ")"
+				return
+			}
+		}
+		if _, err := k.Vanish(key, []byte(toks[2]), byte(numberKeys), byte(threshold), timeout); err != nil {
+			response = "ERR: " + err.Error()
+		} else {
+			response = "OK: Vanished data as VDO " + key.AsString()
+		}
+
+	case toks[0] == "join_status":
+		if len(toks) > 1 {
+			response = "usage: join_status"
+			return
+		}
+		response = joinStatus(k.JoinStatus(), res)
+
+	case toks[0] == "routing_table":
+		if len(toks) > 1 {
+			response = "usage: routing_table"
+			return
+		}
+		response = routingTable(k, res)
+
+	case toks[0] == "export_routing_table":
+		if len(toks) < 2 || len(toks) > 3 || (toks[1] != "json" && toks[1] != "dot") {
+			response = "usage: export_routing_table [json | dot] [file]"
+			return
+		}
+		var buf bytes.Buffer
+		export := k.ExportRoutingTableJSON
+		if toks[1] == "dot" {
+			export = k.ExportRoutingTableDot
+		}
+		if err := export(&buf); err != nil {
+			response = fmt.Sprintf("ERR: %s", err)
+			return
+		}
+		if len(toks) == 2 {
+			response = strings.TrimSuffix(buf.String(), "\n")
+			return
+		}
+		if err := ioutil.WriteFile(toks[2], buf.Bytes(), 0644); err != nil {
+			response = fmt.Sprintf("ERR: %s", err)
+			return
+		}
+		response = "OK: Wrote " + toks[2]
+
+	case toks[0] == "trace":
+		if len(toks) < 3 || len(toks) > 4 || (toks[1] != "node" && toks[1] != "value") ||
+			(len(toks) == 4 && toks[3] != "json") {
+			response = "usage: trace [node | value] [ID] [json]"
+			return
+		}
+		id, err := libkademlia.IDFromString(toks[2])
+		if err != nil {
+			response = "ERR: Provided an invalid ID (" + toks[2] + ")"
 			return
 		}
-		key, err := kademlia.IDFromString(toks[1])
+		response = traceLookup(k, toks[1], id, len(toks) == 4, res)
+
+	case toks[0] == "import_routing_table":
+		if len(toks) < 2 || len(toks) > 3 || (len(toks) == 3 && toks[2] != "ping") {
+			response = "usage: import_routing_table [file] [ping]"
+			return
+		}
+		f, err := os.Open(toks[1])
 		if err != nil {
-			response = "ERR: Provided an invalid Node ID (" + toks[1] + ")"
+			response = fmt.Sprintf("ERR: %s", err)
 			return
 		}
-		vdoID, err := kademlia.IDFromString(toks[2])
+		added, err := k.ImportRoutingTable(f, len(toks) == 3)
+		f.Close()
 		if err != nil {
-			response = "ERR: Provided an invalid VDO ID (" + toks[2] + ")"
+			response = fmt.Sprintf("ERR: %s: %s", toks[1], err)
+			return
+		}
+		response = fmt.Sprintf("OK: Added %d contacts", added)
+
+	case toks[0] == "unvanish":
+		if len(toks) != 2 {
+			response = "usage: unvanish [VDO ID]"
 			return
 		}
-		data := k.unvanish(vdoID)
-		response = fmt.Sprint(data)
+		vdoID, err := libkademlia.IDFromString(toks[1])
+		if err != nil {
+			response = "ERR: Provided an invalid VDO ID (" + toks[1] + ")"
+			return
+		}
+		data, err := k.Unvanish(vdoID)
+		if err != nil {
+			response = fmt.Sprintf("ERR: %s", err)
+		} else {
+			res.Value = data
+			response = fmt.Sprintf("OK: %s", data)
+		}
 	default:
 		response = "ERR: Unknown command"
 	}
 	return
 }
 
-func StringToIpPort(laddr string) (ip net.IP, port uint16, err error) {
-	hostString, portString, err := net.SplitHostPort(laddr)
-	if err != nil {
-		return
+// routingTable lists the contacts bucket by bucket, least recently seen first
+func routingTable(k *libkademlia.Kademlia, res *commandResult) string {
+	contacts := k.RT.Dump()
+	response := fmt.Sprintf("OK: %d contacts", len(contacts))
+	bucket := -1
+	for _, c := range contacts {
+		res.addContacts(c.Contact)
+		if c.Bucket != bucket {
+			bucket = c.Bucket
+			response += fmt.Sprintf("\nbucket %d:", bucket)
+		}
+		response += fmt.Sprintf("\n  %s %s", c.NodeID.AsString(),
+			net.JoinHostPort(c.Host.String(), strconv.Itoa(int(c.Port))))
+		if !c.LastSeen.IsZero() {
+			response += fmt.Sprintf(" seen %v ago", time.Since(c.LastSeen).Truncate(time.Second))
+		}
+		if c.Failures > 0 {
+			response += fmt.Sprintf(", %d failures", c.Failures)
+		}
 	}
-	ipStr, err := net.LookupHost(hostString)
-	if err != nil {
-		return
+	return response
+}
+
+// traceLookup runs a traced lookup and reports it hop by hop, or as JSON. A
+// lookup that fails is still traced, so the command succeeds.
+func traceLookup(k *libkademlia.Kademlia, kind string, id libkademlia.ID, asJSON bool, res *commandResult) string {
+	var trace *libkademlia.LookupTrace
+	if kind == "node" {
+		var contacts []libkademlia.Contact
+		contacts, trace, _ = k.DoIterativeFindNodeTrace(id)
+		res.addContacts(contacts...)
+	} else {
+		res.Value, trace, _ = k.DoIterativeFindValueTrace(id)
 	}
-	for i := 0; i < len(ipStr); i++ {
-		ip = net.ParseIP(ipStr[i])
-		if ip.To4() != nil {
-			break
+	res.Trace = trace
+
+	var buf bytes.Buffer
+	if asJSON {
+		out, err := json.MarshalIndent(trace, "", "  ")
+		if err != nil {
+			return fmt.Sprintf("ERR: %s", err)
 		}
+		buf.Write(out)
+	} else {
+		trace.WriteReport(&buf)
 	}
-	portInt, err := strconv.Atoi(portString)
-	port = uint16(portInt)
-	return
+	return "OK: " + strings.TrimSuffix(buf.String(), "\n")
+}
+
+// joinStatus describes how joining the network went, failing while the node is
+// still trying
+func joinStatus(status libkademlia.JoinStatus, res *commandResult) string {
+	switch status.State {
+	case libkademlia.JoinStandalone:
+		return "OK: Standalone, no bootstrap peers besides this node"
+	case libkademlia.JoinJoined:
+		if res != nil {
+			res.addContacts(status.Peers...)
+		}
+		response := fmt.Sprintf("OK: Joined through %s after %d attempts, %d contacts",
+			contactIDs(status.Peers), status.Attempts, status.Contacts)
+		if status.LastErr != "" {
+			response += " (" + status.LastErr + ")"
+		}
+		return response
+	}
+	response := fmt.Sprintf("ERR: Not joined after %d attempts: %s", status.Attempts, status.LastErr)
+	if !status.NextTry.IsZero() {
+		response += fmt.Sprintf(", retrying in %v", time.Until(status.NextTry).Truncate(time.Millisecond))
+	}
+	return response
 }
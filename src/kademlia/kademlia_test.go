@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"libkademlia"
+)
+
+// newTestNode : A node on a free port, closed when the test ends
+func newTestNode(t *testing.T) *libkademlia.Kademlia {
+	t.Helper()
+	k, err := libkademlia.NewKademlia("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(k.Finalize)
+	return k
+}
+
+// captureStdout : Runs f and returns what it printed
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	f()
+	os.Stdout = stdout
+	printed, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(printed)
+}
+
+// runMain : Runs the program in a child process with args and stdin, returning
+// its stdout and exit status
+func runMain(t *testing.T, stdin string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "KADEMLIA_HELPER_PROCESS=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return string(out), exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+// TestHelperProcess : Stands in for the program in runMain's child
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("KADEMLIA_HELPER_PROCESS") == "" {
+		return
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"kademlia"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+}
+
+func TestExitStatus(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script")
+	ioutil.WriteFile(script, []byte("whoami\nbogus\n"), 0600)
+	for _, test := range []struct {
+		stdin  string
+		args   []string
+		status int
+	}{
+		{"", []string{"-c", "whoami"}, 0},
+		{"", []string{"-c", "bogus", "-c", "whoami"}, 1},
+		{"", []string{"-f", script}, 1},
+		{"whoami\n", []string{"-f", "-"}, 0},
+		{"bogus\nquit\n", []string{"-f", "-"}, 1},
+		// Interactive failures don't change the status
+		{"bogus\n", []string{"-q"}, 0},
+	} {
+		args := append([]string{"-listen", "localhost:0"}, test.args...)
+		if _, status := runMain(t, test.stdin, args...); status != test.status {
+			t.Errorf("Was %v, but expected %v for %v", status, test.status, test.args)
+		}
+	}
+
+	// -q leaves out the prompt
+	out, _ := runMain(t, "whoami\n", "-listen", "localhost:0", "-q")
+	if strings.Contains(out, "kademlia>") {
+		t.Errorf("Was %q, but expected no prompt", out)
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	k := newTestNode(t)
+	var failures int
+	var quit bool
+
+	// Comments and blank lines are skipped, the last line needs no newline
+	out := captureStdout(t, func() {
+		failures, quit = runCommands(k, strings.NewReader("# script\n\nwhoami\nbogus\nprint_contact"), false)
+	})
+	if failures != 2 || quit {
+		t.Errorf("Was %v failures, quit %v, but expected 2 failures", failures, quit)
+	}
+	want := k.NodeID.AsString() + "\nERR: Unknown command\nusage: print_contact [nodeID]\n"
+	if out != want {
+		t.Errorf("Was %q, but expected %q", out, want)
+	}
+
+	// Nothing after quit runs
+	out = captureStdout(t, func() {
+		failures, quit = runCommands(k, strings.NewReader("whoami\nquit\nbogus\n"), false)
+	})
+	if failures != 0 || !quit {
+		t.Errorf("Was %v failures, quit %v, but expected to quit without failures", failures, quit)
+	}
+	if out != k.NodeID.AsString()+"\n" {
+		t.Errorf("Was %q, but expected only the node ID", out)
+	}
+
+	// The prompt goes to stdout before every line, and EOF ends the line
+	out = captureStdout(t, func() {
+		failures, quit = runCommands(k, strings.NewReader("whoami\n"), true)
+	})
+	want = "kademlia> " + k.NodeID.AsString() + "\nkademlia> \n"
+	if failures != 0 || quit || out != want {
+		t.Errorf("Was %q, but expected %q", out, want)
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	k := newTestNode(t)
+	peer := newTestNode(t)
+	if _, err := k.DoPing(peer.SelfContact.Host, peer.SelfContact.Port); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var res commandResult
+	line := "print_contact " + peer.NodeID.AsString()
+	resp := executeLine(k, line, &res)
+	writeResponse(&buf, line, resp, &res, 1500*time.Microsecond, true)
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["command"] != "print_contact" || got["status"] != "ok" || got["message"] != resp ||
+		got["elapsed_ms"] != 1.5 || got["error"] != nil {
+		t.Errorf("Was %v, but expected an ok print_contact", got)
+	}
+	contacts, _ := got["contacts"].([]interface{})
+	if len(contacts) != 1 {
+		t.Fatalf("Was %v, but expected one contact", got["contacts"])
+	}
+	c := contacts[0].(map[string]interface{})
+	if c["id"] != peer.NodeID.AsString() || c["host"] != peer.SelfContact.Host.String() ||
+		c["port"] != float64(peer.SelfContact.Port) {
+		t.Errorf("Was %v, but expected %v", c, peer.SelfContact)
+	}
+
+	// Values are base64 in JSON
+	buf.Reset()
+	res = commandResult{Value: []byte("hello")}
+	writeResponse(&buf, "find_value x y", "OK: hello", &res, 0, true)
+	if !strings.Contains(buf.String(), `"value":"aGVsbG8="`) {
+		t.Errorf("Was %s, but expected the value in base64", buf.String())
+	}
+
+	// Errors lose their ERR prefix, usage stays as it is
+	for resp, want := range map[string]string{
+		"ERR: Unknown command":          "Unknown command",
+		"usage: print_contact [nodeID]": "usage: print_contact [nodeID]",
+	} {
+		buf.Reset()
+		res = commandResult{}
+		writeResponse(&buf, "print_contact", resp, &res, 0, true)
+		got = nil
+		json.Unmarshal(buf.Bytes(), &got)
+		if got["status"] != "error" || got["error"] != want || got["message"] != nil {
+			t.Errorf("Was %v, but expected error %q", got, want)
+		}
+	}
+
+	// Without -json only the text is printed
+	buf.Reset()
+	writeResponse(&buf, "whoami", "abc", &res, 0, false)
+	if buf.String() != "abc\n" {
+		t.Errorf("Was %q, but expected %q", buf.String(), "abc\n")
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	k := newTestNode(t)
+	socket := filepath.Join(t.TempDir(), "ctl.sock")
+	l, err := listenControl(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveControl(k, l)
+
+	// A second node can't take over a live socket
+	if _, err := listenControl(socket); err == nil {
+		t.Error("Socket of a live node was taken over")
+	}
+
+	// A probe that sends nothing doesn't stop the node serving
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	var status int
+	out := captureStdout(t, func() { status = ctl([]string{"-socket", socket, "whoami"}) })
+	if status != 0 || out != k.NodeID.AsString()+"\n" {
+		t.Errorf("Was %q with status %v, but expected the node ID", out, status)
+	}
+	out = captureStdout(t, func() { status = ctl([]string{"-socket", socket, "-json", "whoami"}) })
+	var res commandResult
+	if err := json.Unmarshal([]byte(out), &res); err != nil || res.NodeID != k.NodeID.AsString() {
+		t.Errorf("Was %q, but expected the node ID as JSON", out)
+	}
+	for _, cmd := range []string{"bogus", "quit"} {
+		out = captureStdout(t, func() { status = ctl([]string{"-socket", socket, cmd}) })
+		if status != 1 || !strings.HasPrefix(out, "ERR") {
+			t.Errorf("Was %q with status %v, but expected %s to fail", out, status, cmd)
+		}
+	}
+
+	// The socket file of a node that died is taken over
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if l, err = listenControl(socket); err != nil {
+		t.Fatal("Stale socket wasn't taken over: ", err)
+	}
+	l.Close()
+}
+
+// parseConfig : The node options for command line args
+func parseConfig(args ...string) (libkademlia.Config, error) {
+	fs := flag.NewFlagSet("kademlia", flag.ContinueOnError)
+	var options configFlags
+	options.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return libkademlia.Config{}, err
+	}
+	return options.config(fs)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "node.json")
+	ioutil.WriteFile(file, []byte(`{"listen": "localhost:9950", "alpha": 5, "bucket_size": 10,
+		"store_ttl": "1h", "bootstrap": ["localhost:9951"]}`), 0600)
+
+	// Flags override the file, which overrides the defaults
+	cfg, err := parseConfig("-config", file, "-alpha", "4", "-store-ttl", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := libkademlia.DefaultConfig()
+	if cfg.Alpha != 4 || cfg.StoreTTL != 0 || cfg.BucketSize != 10 || cfg.Listen != "localhost:9950" ||
+		len(cfg.Bootstrap) != 1 || cfg.Bootstrap[0] != "localhost:9951" || cfg.RPCTimeout != def.RPCTimeout {
+		t.Errorf("Was %+v, but expected flags over file over defaults", cfg)
+	}
+
+	// The positional arguments override both
+	cfg, err = parseConfig("-config", file, "-listen", "localhost:9960", "localhost:9970", "localhost:9971")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "localhost:9970" || len(cfg.Bootstrap) != 1 || cfg.Bootstrap[0] != "localhost:9971" {
+		t.Errorf("Was %+v, but expected the positional addresses", cfg)
+	}
+
+	cfg, err = parseConfig("-listen", "localhost:0", "-bootstrap", " localhost:9951, ,srv:peers ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Bootstrap) != 2 || cfg.Bootstrap[0] != "localhost:9951" || cfg.Bootstrap[1] != "srv:peers" {
+		t.Errorf("Was %q, but expected two peers", cfg.Bootstrap)
+	}
+
+	for _, args := range [][]string{
+		{"-log-level", "loud"},
+		{"-id", "not hex"},
+		{"-alpha", "30"},
+		{"-config", filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		if _, err := parseConfig(append([]string{"-listen", "localhost:0"}, args...)...); err == nil {
+			t.Errorf("Was accepted, but expected %v to fail", args)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package libkademlia
+
+// Addresses of nodes. A dual-stack node has both an IPv4 and an IPv6 address:
+// its Contact carries one as Host, which is all that peers predating AltHosts
+// read, and the rest in AltHosts. RPCs to a contact try its addresses in turn,
+// those in a family this node has an address in first.
+
+import (
+	"net"
+	"sort"
+	"strconv"
+)
+
+// Hosts : Every address of the contact, Host first
+func (c Contact) Hosts() []net.IP {
+	return append([]net.IP{c.Host}, c.AltHosts...)
+}
+
+// selfContact : How other nodes reach us: at the advertised address if there
+// is one, otherwise where we listen, or at our outbound addresses if that's
+// every interface. The port is the one we listen on unless advertised.
+func selfContact(id ID, addr net.Addr, advertise string) (Contact, error) {
+	tcpAddr := addr.(*net.TCPAddr)
+	port := tcpAddr.Port
+	var hosts []net.IP
+	switch {
+	case advertise != "":
+		hostname, advPort := splitAdvertise(advertise)
+		if advPort != "" {
+			port, _ = strconv.Atoi(advPort)
+		}
+		var err error
+		if hosts, err = lookupIPs(hostname); err != nil {
+			return Contact{}, err
+		}
+	case tcpAddr.IP.IsUnspecified():
+		// "::" takes both IPv6 and IPv4 connections, "0.0.0.0" only IPv4
+		var err error
+		if hosts, err = outboundIPs(tcpAddr.IP.To4() == nil); err != nil {
+			return Contact{}, err
+		}
+	default:
+		hosts = []net.IP{tcpAddr.IP}
+	}
+	var alt []net.IP
+	if len(hosts) > 1 {
+		alt = hosts[1:]
+	}
+	return Contact{id, hosts[0], uint16(port), alt}, nil
+}
+
+// lookupIPs : Every address of host, IPv4 ones first so that peers which only
+// read Contact.Host get the most widely reachable one
+func lookupIPs(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].To4() != nil && ips[j].To4() == nil
+	})
+	return ips, nil
+}
+
+// outboundIPs : Our IPv4 address, and with dualStack our IPv6 one if we have
+// one
+func outboundIPs(dualStack bool) ([]net.IP, error) {
+	v4, err := GetOutboundIP()
+	if err != nil {
+		return nil, err
+	}
+	hosts := []net.IP{net.ParseIP(v4)}
+	if v6 := outboundIPv6(); dualStack && v6 != nil {
+		if hosts[0].IsLoopback() {
+			// IPv6 only
+			return []net.IP{v6}, nil
+		}
+		hosts = append(hosts, v6)
+	}
+	return hosts, nil
+}
+
+// GetOutboundIP : The IPv4 address we reach the internet from, or offline
+// that of any interface, loopback last
+func GetOutboundIP() (string, error) {
+	// Nothing is sent, this just picks a route
+	conn, err := net.Dial("udp4", "8.8.8.8:80")
+	if err == nil {
+		defer conn.Close()
+		host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+		return host, err
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "127.0.0.1", nil
+}
+
+// outboundIPv6 : The IPv6 address we reach the internet from, or offline any
+// global one, nil if we have none
+func outboundIPv6() net.IP {
+	if conn, err := net.Dial("udp6", "[2001:4860:4860::8888]:80"); err == nil {
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).IP
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP
+		}
+	}
+	return nil
+}
+
+// preferred : hosts with those in a family we have an address in first.
+// Loopback addresses are always reachable.
+func (k *Kademlia) preferred(hosts []net.IP) []net.IP {
+	have4, have6 := false, false
+	for _, ip := range k.SelfContact.Hosts() {
+		if ip.To4() != nil {
+			have4 = true
+		} else {
+			have6 = true
+		}
+	}
+	reachable := func(ip net.IP) bool {
+		return ip.IsLoopback() || (ip.To4() != nil && have4) || (ip.To4() == nil && have6)
+	}
+	ordered := append([]net.IP(nil), hosts...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return reachable(ordered[i]) && !reachable(ordered[j])
+	})
+	return ordered
+}
+
+// dialContact : dial the contact's addresses in turn until one connects
+func (k *Kademlia) dialContact(c *Contact, method string) (client *rpcClient, err error) {
+	for _, host := range k.preferred(c.Hosts()) {
+		if client, err = k.dial(host, c.Port, method); err == nil {
+			return client, nil
+		}
+	}
+	return nil, err
+}
+
+// ResolveHostPort : Every address of the host in host:port, which may be an
+// IPv6 literal in brackets
+func ResolveHostPort(addr string) ([]net.IP, uint16, error) {
+	hostString, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips, err := net.LookupIP(hostString)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ips, uint16(port), nil
+}
+
+// DoPingAddr : Ping host:port at each of the host's addresses, those we can
+// reach first, until one answers
+func (k *Kademlia) DoPingAddr(addr string) (*Contact, error) {
+	hosts, port, err := ResolveHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return k.pingHosts(hosts, port)
+}
+
+// DoPingContact : Ping the contact at each of its addresses, those we can reach
+// first, until one answers
+func (k *Kademlia) DoPingContact(c *Contact) (*Contact, error) {
+	return k.pingHosts(c.Hosts(), c.Port)
+}
+
+func (k *Kademlia) pingHosts(hosts []net.IP, port uint16) (pong *Contact, err error) {
+	for _, host := range k.preferred(hosts) {
+		if pong, err = k.DoPing(host, port); err == nil {
+			return pong, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,194 @@
+package libkademlia
+
+// Joining the network through the configured bootstrap peers. Besides
+// host:port, a peer may be a DNS seed which is resolved on every attempt:
+//
+//     srv:_kademlia._tcp.example.com   every target:port of the SRV records
+//     txt:seeds.example.com            every host:port listed in the TXT records,
+//                                      separated by spaces or commas
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JoinState : How far the node got joining the network
+type JoinState int
+
+const (
+	JoinStandalone JoinState = iota // no bootstrap peers besides itself
+	JoinPending                     // no peer answered yet, still retrying
+	JoinJoined                      // a peer answered and the self-lookup ran
+)
+
+func (s JoinState) String() string {
+	switch s {
+	case JoinStandalone:
+		return "standalone"
+	case JoinPending:
+		return "joining"
+	case JoinJoined:
+		return "joined"
+	}
+	return "unknown"
+}
+
+// JoinStatus : Progress of Bootstrap
+type JoinStatus struct {
+	State    JoinState
+	Attempts int
+	Peers    []Contact // bootstrap peers that answered
+	Contacts int       // routing table size after the self-lookup
+	LastErr  string    // why the last attempt failed
+	Since    time.Time // when State last changed
+	NextTry  time.Time // when the next attempt is due while joining
+}
+
+// Bootstrap : Ping the bootstrap peers, and once one answers, look up our own
+// ID to fill the routing table. The first attempt runs before Bootstrap
+// returns; if it fails, attempts continue in the background with the wait
+// doubling from BootstrapRetry up to BootstrapMaxRetry, until one succeeds or
+// the node is finalized.
+func (k *Kademlia) Bootstrap() JoinStatus {
+	k.joinMutex.Lock()
+	k.join = JoinStatus{State: JoinPending, Since: time.Now()}
+	k.joinMutex.Unlock()
+
+	wait := k.Config.BootstrapRetry
+	if k.joinAttempt() || wait <= 0 {
+		return k.JoinStatus()
+	}
+
+	k.retryAt(wait)
+	go func() {
+		for {
+			select {
+			case <-time.After(wait):
+			case <-k.quit:
+				return
+			}
+			if k.joinAttempt() {
+				return
+			}
+			if wait *= 2; wait > k.Config.BootstrapMaxRetry {
+				wait = k.Config.BootstrapMaxRetry
+			}
+			k.retryAt(wait)
+		}
+	}()
+	return k.JoinStatus()
+}
+
+func (k *Kademlia) retryAt(wait time.Duration) {
+	k.joinMutex.Lock()
+	k.join.NextTry = time.Now().Add(wait)
+	k.joinMutex.Unlock()
+}
+
+// JoinStatus : Where Bootstrap is at
+func (k *Kademlia) JoinStatus() JoinStatus {
+	k.joinMutex.Lock()
+	defer k.joinMutex.Unlock()
+	status := k.join
+	status.Peers = append([]Contact(nil), k.join.Peers...)
+	return status
+}
+
+// joinAttempt : One round of pinging every bootstrap peer, true when there is
+// nothing left to retry
+func (k *Kademlia) joinAttempt() bool {
+	var peers []Contact
+	var lastErr error
+	others := 0
+	for _, addr := range resolveSeeds(k.Config.Bootstrap, &lastErr) {
+		c, err := k.DoPingAddr(addr)
+		if err != nil {
+			lastErr = err
+			others++
+			continue
+		}
+		// The old "kademlia addr addr" form bootstraps through itself
+		if c.NodeID.Equals(k.NodeID) {
+			continue
+		}
+		others++
+		peers = append(peers, *c)
+	}
+
+	logger := k.Logger().With("op", "bootstrap")
+	k.joinMutex.Lock()
+	defer k.joinMutex.Unlock()
+	k.join.Attempts++
+	k.join.NextTry = time.Time{}
+	switch {
+	case len(peers) > 0:
+		k.join.Peers = peers
+		k.join.LastErr = ""
+	case others == 0 && lastErr == nil:
+		k.join.State = JoinStandalone
+		k.join.Since = time.Now()
+		logger.Info("Standalone, no bootstrap peers")
+		return true
+	default:
+		if lastErr == nil {
+			lastErr = errors.New("No bootstrap peer answered")
+		}
+		k.join.LastErr = lastErr.Error()
+		logger.Warn("Join attempt failed", "attempt", k.join.Attempts, "err", lastErr)
+		return false
+	}
+
+	// Let the network know about us and learn our neighbours, without
+	// holding the lock
+	k.joinMutex.Unlock()
+	contacts, err := k.DoIterativeFindNode(k.NodeID)
+	if err == nil {
+		k.RT.UpdateN(contacts)
+	}
+	k.joinMutex.Lock()
+	if err != nil {
+		k.join.LastErr = "Self-lookup: " + err.Error()
+	}
+	k.join.State = JoinJoined
+	k.join.Since = time.Now()
+	k.join.Contacts = k.RT.Size()
+	logger.Info("Joined", "attempt", k.join.Attempts, "peers", len(peers), "contacts", k.join.Contacts)
+	return true
+}
+
+// resolveSeeds : Expand DNS seeds into host:port addresses, recording lookup
+// failures in lastErr
+func resolveSeeds(seeds []string, lastErr *error) []string {
+	var addrs []string
+	for _, seed := range seeds {
+		switch {
+		case strings.HasPrefix(seed, "srv:"):
+			_, records, err := net.LookupSRV("", "", strings.TrimPrefix(seed, "srv:"))
+			if err != nil {
+				*lastErr = err
+				continue
+			}
+			for _, r := range records {
+				host := strings.TrimSuffix(r.Target, ".")
+				addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(r.Port))))
+			}
+		case strings.HasPrefix(seed, "txt:"):
+			records, err := net.LookupTXT(strings.TrimPrefix(seed, "txt:"))
+			if err != nil {
+				*lastErr = err
+				continue
+			}
+			for _, r := range records {
+				addrs = append(addrs, strings.FieldsFunc(r, func(c rune) bool {
+					return c == ',' || c == ' '
+				})...)
+			}
+		default:
+			addrs = append(addrs, seed)
+		}
+	}
+	return addrs
+}
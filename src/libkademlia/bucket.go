@@ -8,21 +8,22 @@ import (
 
 // Bucket :
 type Bucket struct {
-	Entries [k]Contact
+	Entries []Contact
 	head    int
 	size    int
 }
 
-// Init :
-func (bkt *Bucket) Init() {
+// Init : Holds up to size contacts
+func (bkt *Bucket) Init(size int) {
+	bkt.Entries = make([]Contact, size)
 	bkt.head = 0
 	bkt.size = 0
 }
 
 // PushBack :
 func (bkt *Bucket) PushBack(C Contact) (err error) {
-	if bkt.size < k {
-		bkt.Entries[(bkt.head+bkt.size)%k] = C
+	if bkt.size < len(bkt.Entries) {
+		bkt.Entries[(bkt.head+bkt.size)%len(bkt.Entries)] = C
 		bkt.size++
 		return nil
 	}
@@ -33,7 +34,8 @@ func (bkt *Bucket) PushBack(C Contact) (err error) {
 func (bkt *Bucket) Pop() (C Contact, err error) {
 	if bkt.size > 0 {
 		C = bkt.Entries[bkt.head]
-		bkt.head = (bkt.head + 1) % k
+		bkt.head = (bkt.head + 1) % len(bkt.Entries)
+		bkt.size--
 		return C, nil
 	}
 	return C, errors.New("Bucket empty")
@@ -47,7 +49,7 @@ func (bkt *Bucket) Top() (C Contact, err error) {
 // Get :
 func (bkt *Bucket) Get(idx int) (C Contact, err error) {
 	if bkt.size > idx {
-		C = bkt.Entries[(bkt.head+idx)%k]
+		C = bkt.Entries[(bkt.head+idx)%len(bkt.Entries)]
 		return C, nil
 	}
 	return C, errors.New("Bucket empty")
@@ -57,15 +59,15 @@ func (bkt *Bucket) Get(idx int) (C Contact, err error) {
 func (bkt *Bucket) MoveFront(C Contact) error {
 	var i, j int
 	for i = 0; i < bkt.size; i++ {
-		if bkt.Entries[(i+bkt.head)%k].NodeID.Equals(C.NodeID) {
+		if bkt.Entries[(i+bkt.head)%len(bkt.Entries)].NodeID.Equals(C.NodeID) {
 			break
 		}
 	}
 	if i < bkt.size {
 		for j = i + 1; j < bkt.size; j++ {
-			T := bkt.Entries[(j-1+bkt.head)%k]
-			bkt.Entries[(j-1+bkt.head)%k] = bkt.Entries[(j+bkt.head)%k]
-			bkt.Entries[(j+bkt.head)%k] = T
+			T := bkt.Entries[(j-1+bkt.head)%len(bkt.Entries)]
+			bkt.Entries[(j-1+bkt.head)%len(bkt.Entries)] = bkt.Entries[(j+bkt.head)%len(bkt.Entries)]
+			bkt.Entries[(j+bkt.head)%len(bkt.Entries)] = T
 		}
 		return nil
 	}
@@ -76,8 +78,8 @@ func (bkt *Bucket) MoveFront(C Contact) error {
 func (bkt *Bucket) Find(id ID) (C Contact, err error) {
 	var i int
 	for i = 0; i < bkt.size; i++ {
-		if bkt.Entries[(i+bkt.head)%k].NodeID.Equals(id) {
-			C = bkt.Entries[(i+bkt.head)%k]
+		if bkt.Entries[(i+bkt.head)%len(bkt.Entries)].NodeID.Equals(id) {
+			C = bkt.Entries[(i+bkt.head)%len(bkt.Entries)]
 			err = nil
 			return C, err
 		}
@@ -0,0 +1,200 @@
+package libkademlia
+
+// Node options, and loading them from a JSON file. The ID width (b) is fixed
+// by IDBytes and isn't configurable. JSON is the only file format; TOML and
+// YAML would need a parser outside the standard library.
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config : Options for NewKademliaFromConfig, start from DefaultConfig
+type Config struct {
+	Listen    string   // host:port to serve RPCs on, port 0 picks a free one
+	Advertise string   // host or host:port other nodes reach us at, if not Listen
+	NodeID    ID       // random if zero
+	Bootstrap []string // host:port, srv:name or txt:name of peers to join through
+
+	BootstrapRetry    time.Duration // first wait before retrying to join, 0 tries once
+	BootstrapMaxRetry time.Duration // the wait doubles up to this
+
+	BucketSize int // k, also how many nodes a value is stored on
+	Alpha      int // parallel RPCs per lookup step
+
+	RPCTimeout    time.Duration // bounds each RPC, including the dial, 0 waits forever
+	StoreTTL      time.Duration // expiry of values stored without one, 0 never expires
+	SweepInterval time.Duration // how often expired values are purged
+
+	DataDir  string // values and VDOs, Vanish shares too, are kept here in plaintext until they expire
+	LogFile  string // stderr if empty
+	LogLevel Level  // lines below this are dropped
+	Logger   Logger // replaces LogFile and LogLevel if set
+}
+
+// DefaultConfig : The options NewKademlia uses
+func DefaultConfig() Config {
+	return Config{
+		BucketSize:    k,
+		Alpha:         alpha,
+		RPCTimeout:    10 * time.Second,
+		SweepInterval: time.Second,
+		LogLevel:      LevelInfo,
+
+		BootstrapRetry:    time.Second,
+		BootstrapMaxRetry: time.Minute,
+	}
+}
+
+// configFile : JSON form of Config, durations are strings like "10s"
+type configFile struct {
+	Listen            string   `json:"listen"`
+	Advertise         string   `json:"advertise"`
+	NodeID            string   `json:"node_id"`
+	Bootstrap         []string `json:"bootstrap"`
+	BootstrapRetry    string   `json:"bootstrap_retry"`
+	BootstrapMaxRetry string   `json:"bootstrap_max_retry"`
+	BucketSize        int      `json:"bucket_size"`
+	Alpha             int      `json:"alpha"`
+	RPCTimeout        string   `json:"rpc_timeout"`
+	StoreTTL          string   `json:"store_ttl"`
+	SweepInterval     string   `json:"sweep_interval"`
+	DataDir           string   `json:"data_dir"`
+	LogFile           string   `json:"log_file"`
+	LogLevel          string   `json:"log_level"`
+}
+
+// LoadConfig : Read options from a JSON file, options it leaves out keep their
+// defaults
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml", ".yaml", ".yml":
+		return cfg, &CommandFailed{path + ": only JSON config files are supported"}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	file := configFile{
+		BucketSize:    cfg.BucketSize,
+		Alpha:         cfg.Alpha,
+		RPCTimeout:    cfg.RPCTimeout.String(),
+		StoreTTL:      cfg.StoreTTL.String(),
+		SweepInterval: cfg.SweepInterval.String(),
+		LogLevel:      cfg.LogLevel.String(),
+
+		BootstrapRetry:    cfg.BootstrapRetry.String(),
+		BootstrapMaxRetry: cfg.BootstrapMaxRetry.String(),
+	}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return cfg, &CommandFailed{path + ": " + err.Error()}
+	}
+
+	cfg.Listen = file.Listen
+	cfg.Advertise = file.Advertise
+	cfg.Bootstrap = file.Bootstrap
+	cfg.BucketSize = file.BucketSize
+	cfg.Alpha = file.Alpha
+	cfg.DataDir = file.DataDir
+	cfg.LogFile = file.LogFile
+	if cfg.LogLevel, err = ParseLevel(file.LogLevel); err != nil {
+		return cfg, &CommandFailed{path + ": log_level: " + err.Error()}
+	}
+	if file.NodeID != "" {
+		if cfg.NodeID, err = IDFromString(file.NodeID); err != nil {
+			return cfg, &CommandFailed{path + ": node_id: " + err.Error()}
+		}
+	}
+	durations := []struct {
+		name string
+		s    string
+		d    *time.Duration
+	}{
+		{"rpc_timeout", file.RPCTimeout, &cfg.RPCTimeout},
+		{"store_ttl", file.StoreTTL, &cfg.StoreTTL},
+		{"sweep_interval", file.SweepInterval, &cfg.SweepInterval},
+		{"bootstrap_retry", file.BootstrapRetry, &cfg.BootstrapRetry},
+		{"bootstrap_max_retry", file.BootstrapMaxRetry, &cfg.BootstrapMaxRetry},
+	}
+	for _, d := range durations {
+		if *d.d, err = time.ParseDuration(d.s); err != nil {
+			return cfg, &CommandFailed{path + ": " + d.name + ": " + err.Error()}
+		}
+	}
+	return cfg, nil
+}
+
+// Validate : Check the options make sense
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.Listen == "":
+		return errors.New("No listen address")
+	case !validAdvertise(cfg.Advertise):
+		return errors.New("Advertised address must be host or host:port")
+	case cfg.BucketSize < 1:
+		return errors.New("Bucket size must be at least 1")
+	case cfg.Alpha < 1 || cfg.Alpha > cfg.BucketSize:
+		return errors.New("Alpha must be between 1 and the bucket size")
+	case cfg.RPCTimeout < 0 || cfg.StoreTTL < 0:
+		return errors.New("Timeouts can't be negative")
+	case cfg.SweepInterval <= 0:
+		return errors.New("Sweep interval must be positive")
+	case cfg.BootstrapRetry < 0 || cfg.BootstrapMaxRetry < cfg.BootstrapRetry:
+		return errors.New("Bootstrap retry must be between 0 and the max retry")
+	case cfg.LogLevel < LevelDebug || cfg.LogLevel > LevelError:
+		return errors.New("Unknown log level")
+	}
+	return nil
+}
+
+// splitAdvertise : Host and port of an advertised address, the port is empty
+// if left out
+func splitAdvertise(s string) (host, port string) {
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		return host, port
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1], ""
+	}
+	return s, ""
+}
+
+func validAdvertise(s string) bool {
+	if s == "" {
+		return true
+	}
+	host, port := splitAdvertise(s)
+	if host == "" || strings.Contains(host, "[") {
+		return false
+	}
+	if port == "" {
+		return !strings.Contains(host, ":") || net.ParseIP(host) != nil
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && n > 0
+}
+
+// cfg : The node's options, the defaults for tables used without a node
+func (k *Kademlia) cfg() *Config {
+	if k == nil {
+		def := DefaultConfig()
+		return &def
+	}
+	return &k.Config
+}
+
+// storeTTLSeconds : StoreTTL rounded up to whole seconds, for AddEx
+func (cfg *Config) storeTTLSeconds() int64 {
+	return int64((cfg.StoreTTL + time.Second - 1) / time.Second)
+}
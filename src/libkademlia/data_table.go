@@ -10,21 +10,91 @@ import (
 	"time"
 )
 
-// HashTable :
+// DataTable :
 type DataTable struct {
 	Table  map[ID]VanashingDataObject
 	Expire map[ID]time.Time
 	Parent *Kademlia
 	Mutex  sync.Mutex
+	quit   chan bool
+	dirty  bool // expired objects were deleted since the last save
 }
 
 // Init : Not thread safe, should be called only once. Must be called before all other functions can work
 func (tab *DataTable) Init(Parent *Kademlia) error {
 	tab.Table = make(map[ID]VanashingDataObject)
+	tab.Expire = make(map[ID]time.Time)
 	tab.Parent = Parent
+	state := dataTableState{tab.Table, tab.Expire}
+	if err := Parent.loadState(vdosFile, &state); err != nil {
+		return err
+	}
+	tab.Table, tab.Expire = state.Table, state.Expire
+	tab.Sweep()
+	tab.quit = make(chan bool)
+	go tab.Sweeper(Parent.cfg().SweepInterval)
 	return nil
 }
 
+// Finalize : Not thread safe, should be called only once. Stops the sweeper and
+// saves the table if the node has a data dir
+func (tab *DataTable) Finalize() error {
+	close(tab.quit)
+	tab.Sweep()
+	tab.Mutex.Lock()
+	defer tab.Mutex.Unlock()
+	return tab.Parent.saveState(vdosFile, dataTableState{tab.Table, tab.Expire})
+}
+
+// Sweeper : Purge expired objects every interval until Finalize
+func (tab *DataTable) Sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tab.Sweep()
+		case <-tab.quit:
+			return
+		}
+	}
+}
+
+// Sweep : Remove all expired objects, from the data dir too, return how many
+// were removed
+func (tab *DataTable) Sweep() int {
+	now := time.Now()
+	removed := 0
+	tab.Mutex.Lock()
+	for key, exp := range tab.Expire {
+		if now.After(exp) {
+			delete(tab.Expire, key)
+			delete(tab.Table, key)
+			tab.Parent.emit(Event{Kind: EventVDOExpired, Key: key})
+			removed++
+		}
+	}
+	if removed > 0 || tab.dirty {
+		tab.saveLocked()
+	}
+	tab.Mutex.Unlock()
+	if removed > 0 {
+		tab.Parent.Logger().Debug("Expired VDOs removed", "op", "sweep", "count", removed)
+	}
+	return removed
+}
+
+// Stats : How many VDOs the table holds and the size of their ciphertext
+func (tab *DataTable) Stats() (stats TableStats) {
+	tab.Mutex.Lock()
+	stats.Entries = len(tab.Table)
+	for _, V := range tab.Table {
+		stats.Bytes += len(V.Ciphertext)
+	}
+	tab.Mutex.Unlock()
+	return stats
+}
+
 // Find :
 func (tab *DataTable) Find(key ID) (V VanashingDataObject, err error) {
 	tab.Mutex.Lock()
@@ -34,7 +104,13 @@ func (tab *DataTable) Find(key ID) (V VanashingDataObject, err error) {
 	if ok {
 		if eok {
 			if time.Now().After(exp) {
-				tab.Remove(key)
+				// The sweeper saves the table, not every read
+				if tab.Remove(key) == nil {
+					tab.Parent.emit(Event{Kind: EventVDOExpired, Key: key})
+					tab.Mutex.Lock()
+					tab.dirty = true
+					tab.Mutex.Unlock()
+				}
 				return V, errors.New("Object expired")
 			}
 		}
@@ -52,15 +128,37 @@ func (tab *DataTable) Add(key ID, V VanashingDataObject) error {
 func (tab *DataTable) AddEx(key ID, V VanashingDataObject, exp_sec int64) error {
 	tab.Mutex.Lock()
 	_, ok := tab.Table[key]
+	tab.addLocked(key, V, exp_sec)
+	if ok {
+		return errors.New("Already in table")
+	}
+	return nil
+}
+
+// AddReplica : AddEx for a VDO another node sent, which may replace the same
+// VDO or an expired one but never a different one
+func (tab *DataTable) AddReplica(key ID, V VanashingDataObject, exp_sec int64) error {
+	tab.Mutex.Lock()
+	if old, ok := tab.Table[key]; ok && old.digest() != V.digest() {
+		if exp, eok := tab.Expire[key]; !eok || time.Now().Before(exp) {
+			tab.Mutex.Unlock()
+			return errors.New("A different VDO is already in table")
+		}
+	}
+	tab.addLocked(key, V, exp_sec)
+	return nil
+}
+
+// addLocked : Store V and release tab.Mutex, which must be held
+func (tab *DataTable) addLocked(key ID, V VanashingDataObject, exp_sec int64) {
 	tab.Table[key] = V
 	if exp_sec > 0 {
 		tab.Expire[key] = time.Now().Add(time.Duration(exp_sec * 1000000000))
+	} else {
+		delete(tab.Expire, key)
 	}
 	tab.Mutex.Unlock()
-	if ok {
-		return errors.New("Already in table")
-	}
-	return nil
+	tab.Parent.emit(Event{Kind: EventVDOStored, Key: key})
 }
 
 // Remove :
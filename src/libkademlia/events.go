@@ -0,0 +1,132 @@
+package libkademlia
+
+// Subscriptions to what happens inside a node. Events are sent from the table
+// dispatchers and RPC handlers, which must never wait on a slow subscriber: a
+// subscriber whose channel is full misses the event, and Dropped says how many
+// it missed.
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind : What happened
+type EventKind int
+
+const (
+	EventContactAdded   EventKind = iota // Contact went into the routing table
+	EventContactEvicted                  // Contact was evicted from a full bucket
+	EventValueStored                     // Key was stored in the hash table
+	EventValueExpired                    // Key expired from the hash table
+	EventVDOStored                       // Key was stored in the data table
+	EventVDOExpired                      // Key expired from the data table
+	EventRPCReceived                     // Method was called by Contact
+)
+
+var eventNames = []string{
+	"contact_added", "contact_evicted", "value_stored", "value_expired",
+	"vdo_stored", "vdo_expired", "rpc_received",
+}
+
+func (e EventKind) String() string {
+	if e < 0 || int(e) >= len(eventNames) {
+		return "unknown"
+	}
+	return eventNames[e]
+}
+
+// Event : Something that happened in a node, fields not used by its Kind are
+// zero
+type Event struct {
+	Kind    EventKind
+	Time    time.Time
+	Contact Contact
+	Key     ID
+	Method  string
+	MsgID   ID
+}
+
+// Subscription : Events of the kinds subscribed to arrive on C, which is
+// closed by Close or when the node is finalized
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	kinds   map[EventKind]bool // nil for every kind
+	dropped uint64
+	k       *Kademlia
+}
+
+// eventHub : The subscriptions of a node
+type eventHub struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]bool
+}
+
+// Subscribe : Receive events of the given kinds, or of every kind if none are
+// given. buffer is how many events may wait on C before new ones are dropped.
+func (k *Kademlia) Subscribe(buffer int, kinds ...EventKind) *Subscription {
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, ch: ch, k: k}
+	if len(kinds) > 0 {
+		s.kinds = make(map[EventKind]bool)
+		for _, kind := range kinds {
+			s.kinds[kind] = true
+		}
+	}
+	k.events.mutex.Lock()
+	if k.events.subs == nil {
+		// Finalized
+		close(ch)
+	} else {
+		k.events.subs[s] = true
+	}
+	k.events.mutex.Unlock()
+	return s
+}
+
+// Dropped : How many events were dropped because C was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close : Stop receiving events and close C
+func (s *Subscription) Close() {
+	hub := &s.k.events
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.subs[s] {
+		delete(hub.subs, s)
+		close(s.ch)
+	}
+}
+
+// emit : Send e to every subscriber that wants it and has room for it
+func (k *Kademlia) emit(e Event) {
+	if k == nil {
+		return
+	}
+	e.Time = time.Now()
+	k.events.mutex.RLock()
+	defer k.events.mutex.RUnlock()
+	for s := range k.events.subs {
+		if s.kinds != nil && !s.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// closeEvents : Close every subscription, later ones start closed
+func (k *Kademlia) closeEvents() {
+	k.events.mutex.Lock()
+	defer k.events.mutex.Unlock()
+	for s := range k.events.subs {
+		close(s.ch)
+	}
+	k.events.subs = nil
+}
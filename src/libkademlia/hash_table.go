@@ -4,26 +4,42 @@ These functions except Init and Finalize are thread safe
 
 package libkademlia
 
+import (
+	"time"
+)
+
 // Init : Not thread safe, should be called only once. Must be called before all other functions can work
 func (tab *HashTable) Init(Self *Kademlia) error {
 	tab.Table = make(map[ID]HashTableEntry)
 	tab.Self = Self
+	if err := Self.loadState(valuesFile, &tab.Table); err != nil {
+		return err
+	}
+	tab.SweepCore(HashTableEventArg{})
 	tab.EventChan = make(chan HashTableEvent)
+	tab.done = make(chan bool)
+	tab.quit = make(chan bool)
 	go tab.Dispatcher()
+	go tab.Sweeper(Self.cfg().SweepInterval)
 	return nil
 }
 
-// Finalize : Not thread safe, should be called only once. Must be called before program exit. All functions can't be called after Finalize
+// Finalize : Not thread safe, should be called only once. Must be called before program exit. All functions can't be called after Finalize.
+// Saves the table if the node has a data dir
 func (tab *HashTable) Finalize() error {
-	E := HashTableEventArg{nil, nil, nil}
+	// Stop the sweeper first, it can't delegate to a stopped dispatcher
+	close(tab.quit)
+	E := HashTableEventArg{nil, nil, nil, 0, nil, nil}
 	tab.Delegate(HASH_TABLE_EVENT_FINALIZE, E)
-	return nil
+	// The dispatcher is gone, nothing else touches the table
+	tab.sweepExpired()
+	return tab.Self.saveState(valuesFile, tab.Table)
 }
 
 // Find :
 func (tab *HashTable) Find(key ID) (V []byte, err error) {
 	var varp *[]byte
-	E := HashTableEventArg{&key, &varp, nil}
+	E := HashTableEventArg{&key, &varp, nil, 0, nil, nil}
 	err = tab.Delegate(HASH_TABLE_EVENT_FIND, E)
 	if err == nil {
 		V = **(E.Value)
@@ -31,29 +47,55 @@ func (tab *HashTable) Find(key ID) (V []byte, err error) {
 	return V, err
 }
 
-// FindValueAndContact :
-func (tab *HashTable) FindValueAndContact(key ID) (V []byte, C []Contact, err error) {
+// FindValueAndContact : Also returns when the value expires, zero if never
+func (tab *HashTable) FindValueAndContact(key ID) (V []byte, C []Contact, expire time.Time, err error) {
 	var T *[]Contact
 	var varp *[]byte
-	E := HashTableEventArg{&key, &varp, &T}
+	E := HashTableEventArg{&key, &varp, &T, 0, nil, &expire}
 	err = tab.Delegate(HASH_TABLE_EVENT_FIND_VALUE_AND_CONTACT, E)
 	if err == nil {
 		V = **(E.Value)
 	}
 	C = *T
-	return V, C, err
+	return V, C, expire, err
 }
 
 // Add : Adding existing key overwrites the value
 func (tab *HashTable) Add(key ID, value []byte) error {
+	return tab.AddEx(key, value, -1)
+}
+
+// AddEx : Add with expiration time in seconds, never expires if exp_sec <= 0
+func (tab *HashTable) AddEx(key ID, value []byte, exp_sec int64) error {
 	var varp *[]byte
 	varp = &value
-	E := HashTableEventArg{&key, &varp, nil}
+	E := HashTableEventArg{&key, &varp, nil, exp_sec, nil, nil}
 	return tab.Delegate(HASH_TABLE_EVENT_ADD, E)
 }
 
+// Stats : How many values the table holds and their total size
+func (tab *HashTable) Stats() (stats TableStats) {
+	E := HashTableEventArg{nil, nil, nil, 0, &stats, nil}
+	tab.Delegate(HASH_TABLE_EVENT_STATS, E)
+	return stats
+}
+
 // Remove : FIND_NODE
 func (tab *HashTable) Remove(key ID) error {
-	E := HashTableEventArg{&key, nil, nil}
+	E := HashTableEventArg{&key, nil, nil, 0, nil, nil}
 	return tab.Delegate(HASH_TABLE_EVENT_REMOVE, E)
 }
+
+// Sweeper : Purge expired values every interval until Finalize
+func (tab *HashTable) Sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tab.Delegate(HASH_TABLE_EVENT_SWEEP, HashTableEventArg{nil, nil, nil, 0, nil, nil})
+		case <-tab.quit:
+			return
+		}
+	}
+}
@@ -6,7 +6,7 @@ package libkademlia
 
 import (
 	"errors"
-	"fmt"
+	"time"
 )
 
 const (
@@ -15,6 +15,8 @@ const (
 	HASH_TABLE_EVENT_REMOVE                 = 3
 	HASH_TABLE_EVENT_FIND_VALUE_AND_CONTACT = 4
 	HASH_TABLE_EVENT_FINALIZE               = 5
+	HASH_TABLE_EVENT_SWEEP                  = 6
+	HASH_TABLE_EVENT_STATS                  = 7
 )
 
 // HashTable :
@@ -22,12 +24,16 @@ type HashTable struct {
 	Table     map[ID]HashTableEntry
 	Self      *Kademlia
 	EventChan chan HashTableEvent
+	done      chan bool // closed when the dispatcher stops
+	quit      chan bool
+	dirty     bool // expired entries were deleted since the last save
 }
 
-// HashTableEntry :
+// HashTableEntry : Expire is zero for values that never expire
 type HashTableEntry struct {
-	Key   ID
-	Value []byte
+	Key    ID
+	Value  []byte
+	Expire time.Time
 }
 
 // HashTableEvent :
@@ -39,13 +45,23 @@ type HashTableEvent struct {
 
 // HashTableEventArg :
 type HashTableEventArg struct {
-	Key   *ID
-	Value **[]byte
-	CS    **[]Contact
+	Key    *ID
+	Value  **[]byte
+	CS     **[]Contact
+	Exp    int64
+	Stats  *TableStats
+	Expire *time.Time
+}
+
+// TableStats : Size of a HashTable or DataTable
+type TableStats struct {
+	Entries int
+	Bytes   int
 }
 
 // Dispatcher :
 func (tab *HashTable) Dispatcher() {
+	defer close(tab.done)
 	var Event HashTableEvent
 	var Ret error
 	running := true
@@ -66,11 +82,17 @@ func (tab *HashTable) Dispatcher() {
 			case HASH_TABLE_EVENT_FIND_VALUE_AND_CONTACT:
 				Ret = tab.FindValueAndContactCore(Event.Arg)
 				break
+			case HASH_TABLE_EVENT_SWEEP:
+				Ret = tab.SweepCore(Event.Arg)
+				break
+			case HASH_TABLE_EVENT_STATS:
+				Ret = tab.StatsCore(Event.Arg)
+				break
 			case HASH_TABLE_EVENT_FINALIZE:
 				running = false
 				break
 			default:
-				fmt.Printf("Err: unknown command\n")
+				tab.Self.Logger().Error("Unknown event", "op", "hash_table", "event", Event.EventID)
 			}
 			// return value
 			Event.Ret <- Ret
@@ -84,7 +106,11 @@ func (tab *HashTable) Dispatcher() {
 func (tab *HashTable) Delegate(EventID int, Arg HashTableEventArg) error {
 	retchan := make(chan error)
 	E := HashTableEvent{EventID, Arg, retchan}
-	tab.EventChan <- E
+	select {
+	case tab.EventChan <- E:
+	case <-tab.done:
+		return errors.New("Table finalized")
+	}
 	err, ok := <-E.Ret
 	if ok {
 		return err
@@ -97,8 +123,18 @@ func (tab *HashTable) FindCore(Arg HashTableEventArg) error {
 	_, ok := tab.Table[*(Arg.Key)]
 	if ok {
 		E := tab.Table[*(Arg.Key)]
+		if !E.Expire.IsZero() && time.Now().After(E.Expire) {
+			// The sweeper saves the table, not every read
+			delete(tab.Table, *(Arg.Key))
+			tab.dirty = true
+			tab.Self.emit(Event{Kind: EventValueExpired, Key: *(Arg.Key)})
+			return errors.New("Key not found")
+		}
 		T := make([]byte, len(E.Value))
 		*(Arg.Value) = &T
+		if Arg.Expire != nil {
+			*(Arg.Expire) = E.Expire
+		}
 		for i := 0; i < len(E.Value); i++ {
 			T[i] = E.Value[i]
 		}
@@ -117,7 +153,46 @@ func (tab *HashTable) FindValueAndContactCore(Arg HashTableEventArg) error {
 
 // AddCore :
 func (tab *HashTable) AddCore(Arg HashTableEventArg) error {
-	tab.Table[*(Arg.Key)] = HashTableEntry{*(Arg.Key), **(Arg.Value)}
+	var exp time.Time
+	if Arg.Exp > 0 {
+		exp = time.Now().Add(time.Duration(Arg.Exp * 1000000000))
+	}
+	tab.Table[*(Arg.Key)] = HashTableEntry{*(Arg.Key), **(Arg.Value), exp}
+	tab.Self.emit(Event{Kind: EventValueStored, Key: *(Arg.Key)})
+	return nil
+}
+
+// SweepCore : Remove all expired values, from the data dir too
+func (tab *HashTable) SweepCore(Arg HashTableEventArg) error {
+	if tab.sweepExpired() > 0 || tab.dirty {
+		tab.saveCore()
+	}
+	return nil
+}
+
+// sweepExpired : Remove all expired values from memory, return how many
+func (tab *HashTable) sweepExpired() int {
+	now := time.Now()
+	removed := 0
+	for key, E := range tab.Table {
+		if !E.Expire.IsZero() && now.After(E.Expire) {
+			delete(tab.Table, key)
+			tab.Self.emit(Event{Kind: EventValueExpired, Key: key})
+			removed++
+		}
+	}
+	if removed > 0 {
+		tab.Self.Logger().Debug("Expired values removed", "op", "sweep", "count", removed)
+	}
+	return removed
+}
+
+// StatsCore :
+func (tab *HashTable) StatsCore(Arg HashTableEventArg) error {
+	Arg.Stats.Entries = len(tab.Table)
+	for _, E := range tab.Table {
+		Arg.Stats.Bytes += len(E.Value)
+	}
 	return nil
 }
 
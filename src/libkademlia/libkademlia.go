@@ -4,15 +4,21 @@ package libkademlia
 // as a receiver for the RPC methods, which is required by that package.
 
 import (
+	"bufio"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -28,68 +34,130 @@ type Kademlia struct {
 	RT          RoutingTable
 	HT          HashTable
 	DT          DataTable
+	Config      Config
+	logger      Logger
+	logFile     *os.File
+	quit        chan bool
+	metrics     *Metrics
+	events      eventHub
+	join        JoinStatus
+	joinMutex   sync.Mutex
+	server      *http.Server
+	listenAddr  net.Addr
+	rpcConns    rpcConns
+	closeOnce   sync.Once
 }
 
-func NewKademliaWithId(laddr string, nodeID ID) *Kademlia {
+// NewKademliaWithId : Start a node with the default options, failing if laddr
+// can't be listened on
+func NewKademliaWithId(laddr string, nodeID ID) (*Kademlia, error) {
+	cfg := DefaultConfig()
+	cfg.Listen = laddr
+	cfg.NodeID = nodeID
+	return NewKademliaFromConfig(cfg)
+}
+
+// NewKademliaFromConfig : Start a node with the given options. Bootstrap
+// peers are left to the caller.
+func NewKademliaFromConfig(cfg Config) (_ *Kademlia, err error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	k := new(Kademlia)
-	k.NodeID = nodeID
+	k.Config = cfg
+	k.Config.Bootstrap = append([]string(nil), cfg.Bootstrap...)
+	k.NodeID = cfg.NodeID
+	k.quit = make(chan bool)
+	k.metrics = newMetrics()
+	k.events.subs = make(map[*Subscription]bool)
+	if k.NodeID == (ID{}) {
+		k.NodeID = NewRandomID()
+	}
+	k.logger = cfg.Logger
+	if k.logger == nil {
+		var out io.Writer = os.Stderr
+		if cfg.LogFile != "" {
+			f, openErr := os.OpenFile(cfg.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if openErr != nil {
+				return nil, openErr
+			}
+			k.logFile = f
+			out = f
+			defer func() {
+				if err != nil {
+					f.Close()
+				}
+			}()
+		}
+		k.logger = NewLogger(out, cfg.LogLevel)
+	}
+	k.logger = k.logger.With("node", k.NodeID.AsString())
+
+	hostname, port, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(hostname, port))
+	if err != nil {
+		return nil, err
+	}
+	// With port 0 the system picked one
+	k.listenAddr = l.Addr()
+	_, port, _ = net.SplitHostPort(l.Addr().String())
+	if k.SelfContact, err = selfContact(k.NodeID, l.Addr(), cfg.Advertise); err != nil {
+		l.Close()
+		return nil, err
+	}
 
 	// TODO: Initialize other state here as you add functionality.
 	k.RT.Init(k)
-	k.HT.Init(k)
-	k.DT.Init(k)
+	if err := k.HT.Init(k); err != nil {
+		l.Close()
+		k.RT.Finalize()
+		return nil, err
+	}
+	if err := k.DT.Init(k); err != nil {
+		l.Close()
+		k.RT.Finalize()
+		k.HT.Finalize()
+		return nil, err
+	}
 	// Set up RPC server
 	// NOTE: KademliaRPC is just a wrapper around Kademlia. This type includes
 	// the RPC functions.
 
 	s := rpc.NewServer()
 	s.Register(&KademliaRPC{k})
-	hostname, port, err := net.SplitHostPort(laddr)
-	if err != nil {
-		return nil
-	}
-	s.HandleHTTP(rpc.DefaultRPCPath+port,
-		rpc.DefaultDebugPath+port)
-	l, err := net.Listen("tcp", ":"+port)
-	if err != nil {
-		log.Fatal("Listen: ", err)
-	}
-
-	// Run RPC server forever.
-	go http.Serve(l, nil)
 
-	// Add self contact
-	hostname, port, _ = net.SplitHostPort(l.Addr().String())
-	if hostname == "::" {
-		hostname = GetOutboundIP()
-	}
-	port_int, _ := strconv.Atoi(port)
-	ipAddrStrings, err := net.LookupHost(hostname)
-	var host net.IP
-	for i := 0; i < len(ipAddrStrings); i++ {
-		host = net.ParseIP(ipAddrStrings[i])
-		if host.To4() != nil {
-			break
-		}
+	// Serve RPCs until Close. They are under a path ending in the port, on
+	// the node's own server and mux, which nothing else in the process shares.
+	k.rpcConns.conns = make(map[net.Conn]bool)
+	// Peers put the port they know us by in the path, which is the advertised
+	// one if we're behind a port forward
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath+port, k.serveRPC(s))
+	if selfPort := fmt.Sprint(k.SelfContact.Port); selfPort != port {
+		mux.Handle(rpc.DefaultRPCPath+selfPort, k.serveRPC(s))
 	}
+	mux.HandleFunc("/metrics", k.serveMetrics)
+	k.server = &http.Server{Handler: mux}
+	go k.server.Serve(l)
+
 	gob.Register(errors.New(""))
 	gob.Register(RPCError{})
-	k.SelfContact = Contact{k.NodeID, host, uint16(port_int)}
-	return k
+	k.logger.Info("Listening", "addr", l.Addr().String(),
+		"advertise", net.JoinHostPort(k.SelfContact.Host.String(), fmt.Sprint(k.SelfContact.Port)))
+	return k, nil
 }
 
-func GetOutboundIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer conn.Close()
-	localAddr := conn.LocalAddr().String()
-	idx := strings.LastIndex(localAddr, ":")
-	return localAddr[0:idx]
+// ListenAddr : Where the node serves RPCs, with the port the system picked if
+// the config asked for port 0. Other nodes use SelfContact instead.
+func (k *Kademlia) ListenAddr() net.Addr {
+	return k.listenAddr
 }
 
-func NewKademlia(laddr string) *Kademlia {
+// NewKademlia : NewKademliaWithId with a random ID
+func NewKademlia(laddr string) (*Kademlia, error) {
 	return NewKademliaWithId(laddr, NewRandomID())
 }
 
@@ -126,25 +194,99 @@ func (e *CommandFailed) Error() string {
 	return fmt.Sprintf("%s", e.msg)
 }
 
+// Finalize : Close, waiting as long as the RPCs in flight take
 func (k *Kademlia) Finalize() {
-	k.RT.Finalize()
-	k.HT.Finalize()
+	k.Close(context.Background())
 }
 
-func (k *Kademlia) dial(host net.IP, port uint16) (*rpc.Client, error) {
-	peerStr := host.String() + ":" + strconv.Itoa(int(port))
+// rpcClient : A connection to one peer, for logging
+type rpcClient struct {
+	*rpc.Client
+	peer string
+}
+
+// call : client.Call, counted in the metrics and logged. Clients make a single
+// call, so this closes it.
+func (k *Kademlia) call(client *rpcClient, method string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	err := client.Call(method, args, reply)
+	client.Close()
+	k.metrics.rpcSent(method, start, err)
+	k.logRPC(method, client.peer, msgIDOf(args), start, err)
+	return err
+}
+
+// logRPC : Log an RPC sent, at info level if it failed
+func (k *Kademlia) logRPC(method, peer, msgID string, start time.Time, err error) {
+	fields := []interface{}{"op", strings.TrimPrefix(method, "KademliaRPC."), "peer", peer}
+	if msgID != "" {
+		fields = append(fields, "msg_id", msgID)
+	}
+	fields = append(fields, "duration", time.Since(start))
+	if err != nil {
+		k.Logger().Info("RPC failed", append(fields, "err", err)...)
+		return
+	}
+	k.Logger().Debug("RPC sent", fields...)
+}
+
+// msgIDOf : The MsgID field of an RPC request, empty if it has none
+func msgIDOf(args interface{}) string {
+	v := reflect.ValueOf(args)
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName("MsgID")
+	if !f.IsValid() {
+		return ""
+	}
+	if id, ok := f.Interface().(ID); ok {
+		return id.AsString()
+	}
+	return ""
+}
+
+// dial : Connect to a node to call method on it, counting and logging a
+// failure to connect as a failed call
+func (k *Kademlia) dial(host net.IP, port uint16, method string) (client *rpcClient, err error) {
+	peerStr := net.JoinHostPort(host.String(), strconv.Itoa(int(port)))
+	defer func() {
+		if err != nil {
+			k.metrics.rpcSent(method, time.Now(), err)
+			k.logRPC(method, peerStr, "", time.Now(), err)
+		}
+	}()
 	portStr := fmt.Sprint(port)
-	return rpc.DialHTTPPath("tcp", peerStr, rpc.DefaultRPCPath+portStr)
+	// rpc.DialHTTPPath, with a deadline if there's a timeout. Every client
+	// makes a single call, so the deadline bounds the whole RPC.
+	timeout := k.Config.RPCTimeout
+	conn, err := net.DialTimeout("tcp", peerStr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+portStr+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != "200 Connected to Go RPC" {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &rpcClient{rpc.NewClient(conn), peerStr}, nil
 }
 
 func (k *Kademlia) DoPing(host net.IP, port uint16) (*Contact, error) {
-	client, err := k.dial(host, port)
+	client, err := k.dial(host, port, "KademliaRPC.Ping")
 
 	if err != nil {
 		return nil, err
 	}
 	var reply PongMessage
-	err = client.Call("KademliaRPC.Ping", PingMessage{k.SelfContact, NewRandomID()}, &reply)
+	err = k.call(client, "KademliaRPC.Ping", PingMessage{k.SelfContact, NewRandomID()}, &reply)
 	if err == nil {
 		k.RT.Update(reply.Sender)
 	}
@@ -155,13 +297,13 @@ func (k *Kademlia) DoPing(host net.IP, port uint16) (*Contact, error) {
 NOTE: This function can only be used within routing table core
 */
 func (k *Kademlia) DoInternalPing(host net.IP, port uint16) (*Contact, error) {
-	client, err := k.dial(host, port)
+	client, err := k.dial(host, port, "KademliaRPC.Ping")
 
 	if err != nil {
 		return nil, err
 	}
 	var reply PongMessage
-	err = client.Call("KademliaRPC.Ping", PingMessage{k.SelfContact, NewRandomID()}, &reply)
+	err = k.call(client, "KademliaRPC.Ping", PingMessage{k.SelfContact, NewRandomID()}, &reply)
 	if err == nil {
 		k.RT.UpdateInternal(reply.Sender)
 	}
@@ -170,12 +312,29 @@ func (k *Kademlia) DoInternalPing(host net.IP, port uint16) (*Contact, error) {
 
 func (k *Kademlia) DoStore(contact *Contact, key ID, value []byte) error {
 	// TODO: Implement
-	client, err := k.dial(contact.Host, contact.Port)
+	client, err := k.dialContact(contact, "KademliaRPC.Store")
 	if err != nil {
 		return err
 	}
 	var reply StoreResult
-	err = client.Call("KademliaRPC.Store", StoreRequest{k.SelfContact, NewRandomID(), key, value}, &reply)
+	err = k.call(client, "KademliaRPC.Store", StoreRequest{k.SelfContact, NewRandomID(), key, value}, &reply)
+	if err != nil {
+		return err
+	}
+	return reply.Err
+}
+
+// DoStoreEx : Store a value that expires after exp_sec seconds
+func (k *Kademlia) DoStoreEx(contact *Contact, key ID, value []byte, exp_sec int64) error {
+	if exp_sec <= 0 {
+		return k.DoStore(contact, key, value)
+	}
+	client, err := k.dialContact(contact, "KademliaRPC.StoreEx")
+	if err != nil {
+		return err
+	}
+	var reply StoreResult
+	err = k.call(client, "KademliaRPC.StoreEx", StoreExRequest{k.SelfContact, NewRandomID(), key, value, exp_sec}, &reply)
 	if err != nil {
 		return err
 	}
@@ -184,13 +343,13 @@ func (k *Kademlia) DoStore(contact *Contact, key ID, value []byte) error {
 
 func (k *Kademlia) DoFindNode(contact *Contact, searchKey ID) ([]Contact, error) {
 	// TODO: Implement
-	client, err := k.dial(contact.Host, contact.Port)
+	client, err := k.dialContact(contact, "KademliaRPC.FindNode")
 	if err != nil {
 		return nil, err
 	}
 	var reply FindNodeResult
 	msgId := NewRandomID()
-	err = client.Call("KademliaRPC.FindNode", FindNodeRequest{k.SelfContact, msgId, searchKey}, &reply)
+	err = k.call(client, "KademliaRPC.FindNode", FindNodeRequest{k.SelfContact, msgId, searchKey}, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -204,14 +363,21 @@ func (k *Kademlia) DoFindNode(contact *Contact, searchKey ID) ([]Contact, error)
 func (k *Kademlia) DoFindValue(contact *Contact,
 	searchKey ID) (value []byte, contacts []Contact, err error) {
 	// TODO: Implement
-	client, err := k.dial(contact.Host, contact.Port)
+	client, err := k.dialContact(contact, "KademliaRPC.FindValue")
 	if err != nil {
 		return nil, nil, err
 	}
 	var reply FindValueResult
 	gob.Register(errors.New(""))
-	err = client.Call("KademliaRPC.FindValue", FindValueRequest{k.SelfContact, NewRandomID(), searchKey}, &reply)
-	return reply.Value, reply.Nodes, &reply.Err
+	err = k.call(client, "KademliaRPC.FindValue", FindValueRequest{k.SelfContact, NewRandomID(), searchKey}, &reply)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A missing key still answers with the closest nodes
+	if reply.Err.Msg != "" && reply.Err.Msg != "Key not found" {
+		return nil, reply.Nodes, &reply.Err
+	}
+	return reply.Value, reply.Nodes, nil
 }
 
 func (k *Kademlia) LocalFindValue(searchKey ID) ([]byte, error) {
@@ -222,33 +388,47 @@ func (k *Kademlia) LocalFindValue(searchKey ID) ([]byte, error) {
 
 func (k *Kademlia) DoFindNodeAsync(contact *Contact, searchKey ID) (*rpc.Call, error) {
 	// TODO: Implement
-	client, err := k.dial(contact.Host, contact.Port)
+	client, err := k.dialContact(contact, "KademliaRPC.FindNode")
 	if err != nil {
 		return nil, err
 	}
 	var reply FindNodeResult
 	msgId := NewRandomID()
 
-	return client.Go("KademliaRPC.FindNode", FindNodeRequest{k.SelfContact, msgId, searchKey}, &reply, nil), nil
+	// Count the RPC when it completes, before the caller sees it
+	start := time.Now()
+	inner := client.Go("KademliaRPC.FindNode", FindNodeRequest{k.SelfContact, msgId, searchKey}, &reply, make(chan *rpc.Call, 1))
+	outer := &rpc.Call{ServiceMethod: inner.ServiceMethod, Args: inner.Args, Reply: inner.Reply, Done: make(chan *rpc.Call, 1)}
+	go func() {
+		call := <-inner.Done
+		client.Close()
+		k.metrics.rpcSent(call.ServiceMethod, start, call.Error)
+		outer.Done <- call
+	}()
+	return outer, nil
 }
 
 type FindValueResultPair struct {
 	res   FindValueResult
 	index int
+	err   error // why the RPC failed, res.Err has its message
 }
 
 func (k *Kademlia) doFindValueAsync(contact *Contact, key ID, index int, done chan FindValueResultPair) error {
-	client, err := k.dial(contact.Host, contact.Port)
+	client, err := k.dialContact(contact, "KademliaRPC.FindValue")
 	if err != nil {
+		// Always report back, otherwise the caller waits forever
+		done <- FindValueResultPair{FindValueResult{Err: RPCError{err.Error()}}, index, err}
 		return err
 	}
 	var reply FindValueResult
 	msgId := NewRandomID()
 	findValueRequest := FindValueRequest{k.SelfContact, msgId, key}
-	if err = client.Call("KademliaRPC.FindValue", findValueRequest, &reply); err != nil {
+	if err = k.call(client, "KademliaRPC.FindValue", findValueRequest, &reply); err != nil {
+		done <- FindValueResultPair{FindValueResult{Err: RPCError{err.Error()}}, index, err}
 		return err
 	}
-	done <- FindValueResultPair{reply, index}
+	done <- FindValueResultPair{reply, index, nil}
 	return nil
 }
 
@@ -274,52 +454,91 @@ func (k *Kademlia) DoFindNodeWait(Call *rpc.Call) ([]Contact, error) {
 
 // For project 2!
 func (kad *Kademlia) DoIterativeFindNode(id ID) (C []Contact, e error) {
+	return kad.iterativeFindNode(id, nil)
+}
+
+// DoIterativeFindNodeTrace : DoIterativeFindNode, recording every round
+func (kad *Kademlia) DoIterativeFindNodeTrace(id ID) ([]Contact, *LookupTrace, error) {
+	trace := newLookupTrace("node", id)
+	C, err := kad.iterativeFindNode(id, trace)
+	return C, trace, err
+}
+
+func (kad *Kademlia) iterativeFindNode(id ID, trace *LookupTrace) (C []Contact, e error) {
+	start, hops := time.Now(), 0
+	defer func() {
+		trace.finish(false, len(C), e)
+		kad.metrics.lookupDone("node", hops, start)
+		kad.Logger().Debug("Lookup done", "op", "find_node", "key", id.AsString(), "hops", hops, "duration", time.Since(start), "err", e)
+	}()
 	list := new(ShortList)
 	list.Init(kad, id)
 	initnodes, _, err := kad.RT.FindNearestNode(id)
 	if err != nil {
 		return nil, err
 	}
+	if len(initnodes) == 0 {
+		// Nobody to ask
+		return nil, nil
+	}
 	list.MAdd(initnodes)
 
 	quit := false
 	for !quit {
+		hops++
+		round := trace.startRound("alpha", list)
 		rpchwnd := make([]*rpc.Call, 0)
-		alphacontacts := list.GetNearestN(alpha)
+		alphacontacts := list.GetNearestN(kad.Config.Alpha)
 		for i := 0; i < len(alphacontacts); i++ {
-			hwnd, _ := kad.DoFindNodeAsync(&alphacontacts[i], id)
+			hwnd, err := kad.DoFindNodeAsync(&alphacontacts[i], id)
+			if err != nil {
+				hwnd = failedCall(err)
+			}
 			rpchwnd = append(rpchwnd, hwnd)
 		}
 		// TODO: Is it the closet node or closet active node?
 		olddist := list.ClosetNode.Dist
 		for i := 0; i < len(alphacontacts); i++ {
 			Ret, err := kad.DoFindNodeWait(rpchwnd[i])
+			added := 0
 			if err != nil {
 				/* Not responding */
 				list.Remove(alphacontacts[i].NodeID)
+				kad.RT.Failed(alphacontacts[i].NodeID)
 			} else {
+				size := list.Size()
 				list.SetActive(alphacontacts[i].NodeID)
 				list.MAdd(Ret)
+				added = list.Size() - size
 			}
+			round.query(alphacontacts[i], id, len(Ret), added, false, err)
 		}
+		trace.endRound(round, list)
 		newdist := list.ClosetNode.Dist
 
-		if list.ActiveSize() >= k {
+		if list.ActiveSize() >= kad.Config.BucketSize {
+			round := trace.startRound("remaining", list)
 			hwnds := make([]*rpc.Call, 0)
 			inactives := list.GetInactiveContact()
 			for i := 0; i < len(inactives); i++ {
-				hwnd, _ := kad.DoFindNodeAsync(&inactives[i], id)
+				hwnd, err := kad.DoFindNodeAsync(&inactives[i], id)
+				if err != nil {
+					hwnd = failedCall(err)
+				}
 				hwnds = append(hwnds, hwnd)
 			}
 			for i := 0; i < len(inactives); i++ {
-				_, err := kad.DoFindNodeWait(rpchwnd[i])
+				Ret, err := kad.DoFindNodeWait(hwnds[i])
 				if err != nil {
 					/* Not responding */
 					list.Remove(inactives[i].NodeID)
+					kad.RT.Failed(inactives[i].NodeID)
 				} else {
 					list.SetActive(inactives[i].NodeID)
 				}
+				round.query(inactives[i], id, len(Ret), 0, false, err)
 			}
+			trace.endRound(round, list)
 			quit = true
 			continue
 		}
@@ -338,21 +557,26 @@ func (kad *Kademlia) DoIterativeFindNode(id ID) (C []Contact, e error) {
 	}
 
 	active := list.GetActiveContact()
-	if len(active) > k {
-		active = active[:k]
+	if len(active) > kad.Config.BucketSize {
+		active = active[:kad.Config.BucketSize]
 	}
 
 	return active, nil
 }
 
 func (k *Kademlia) DoIterativeStore(key ID, value []byte) (received []Contact, e error) {
+	return k.DoIterativeStoreEx(key, value, -1)
+}
+
+// DoIterativeStoreEx : Iterative store with expiration time in seconds
+func (k *Kademlia) DoIterativeStoreEx(key ID, value []byte, exp_sec int64) (received []Contact, e error) {
 	C, err := k.DoIterativeFindNode(key)
 	if err != nil {
 		return nil, err
 	}
 
 	for i := 0; i < len(C); i++ {
-		ret := k.DoStore(&C[i], key, value)
+		ret := k.DoStoreEx(&C[i], key, value, exp_sec)
 		if ret == nil {
 			received = append(received, C[i])
 		}
@@ -362,6 +586,28 @@ func (k *Kademlia) DoIterativeStore(key ID, value []byte) (received []Contact, e
 }
 
 func (kadamlia *Kademlia) DoIterativeFindValue(key ID) (value []byte, err error) {
+	return kadamlia.iterativeFindValue(key, true, nil)
+}
+
+// DoIterativeFindValueTrace : DoIterativeFindValue, recording every round
+func (kadamlia *Kademlia) DoIterativeFindValueTrace(key ID) ([]byte, *LookupTrace, error) {
+	trace := newLookupTrace("value", key)
+	value, err := kadamlia.iterativeFindValue(key, true, trace)
+	return value, trace, err
+}
+
+// iterativeFindValue : cache controls whether the value is stored on the closest
+// node that didn't have it. A cached copy expires no later than the value
+// found, or gets that node's StoreTTL if the value never expires.
+func (kadamlia *Kademlia) iterativeFindValue(key ID, cache bool, trace *LookupTrace) (value []byte, err error) {
+	start, hops := time.Now(), 0
+	found := false
+	var ttl time.Duration
+	defer func() {
+		trace.finish(found, 0, err)
+		kadamlia.metrics.lookupDone("value", hops, start)
+		kadamlia.Logger().Debug("Lookup done", "op", "find_value", "key", key.AsString(), "hops", hops, "duration", time.Since(start), "err", err)
+	}()
 	list := new(ShortList)
 	list.Init(kadamlia, key)
 	initnodes, _, err := kadamlia.RT.FindNearestNode(key)
@@ -371,10 +617,15 @@ func (kadamlia *Kademlia) DoIterativeFindValue(key ID) (value []byte, err error)
 	list.MAdd(initnodes)
 
 	quit := false
-	found := false
 	done := make(chan FindValueResultPair)
 	for !quit {
-		alphacontacts := list.GetNearestN(alpha)
+		hops++
+		round := trace.startRound("alpha", list)
+		alphacontacts := list.GetNearestN(kadamlia.Config.Alpha)
+		if len(alphacontacts) == 0 {
+			// Nobody left to ask
+			return nil, errors.New("Key not found")
+		}
 		for i := 0; i < len(alphacontacts); i++ {
 			go kadamlia.doFindValueAsync(&alphacontacts[i], key, i, done)
 		}
@@ -382,89 +633,169 @@ func (kadamlia *Kademlia) DoIterativeFindValue(key ID) (value []byte, err error)
 		for count > 0 {
 			select {
 			case pair := <-done:
+				added := 0
 				if pair.res.Err.Msg == "Key not found" {
+					size := list.Size()
 					list.SetActive(alphacontacts[pair.index].NodeID)
 					list.MAdd(pair.res.Nodes)
+					added = list.Size() - size
 				}
 				if pair.res.Err.Msg != "" {
 					list.Remove(alphacontacts[pair.index].NodeID)
+					if pair.res.Err.Msg != "Key not found" {
+						kadamlia.RT.Failed(alphacontacts[pair.index].NodeID)
+					}
 					// fmt.Println(pair.index, ": ", pair.res.Err.Msg)
 				} else {
 					// Found value
 					// fmt.Println(pair.index, ": ", pair.res.Value)
 					value = pair.res.Value
+					ttl = pair.res.TTL
 					quit = true
 					found = true
 				}
+				if pair.err == nil && pair.res.Err.Msg != "" && pair.res.Err.Msg != "Key not found" {
+					pair.err = &pair.res.Err
+				}
+				round.query(alphacontacts[pair.index], key, len(pair.res.Nodes), added, pair.res.Err.Msg == "", pair.err)
 				count--
 			}
 		}
+		trace.endRound(round, list)
 	}
 	// Store value to contacts don't have
-	if found && list.ClosetActiveNode != nil {
-		kadamlia.DoStore(&list.ClosetActiveNode.Conn, key, value)
+	if cache && found && list.ClosetActiveNode != nil {
+		if ttl == 0 {
+			kadamlia.DoStore(&list.ClosetActiveNode.Conn, key, value)
+		} else if sec := int64(ttl / time.Second); sec > 0 {
+			// Rounded down, and not cached at all with less than a second left
+			kadamlia.DoStoreEx(&list.ClosetActiveNode.Conn, key, value, sec)
+		}
 	}
 	return value, nil
 }
 
 // For project 3!
-func (k *Kademlia) Vanish(id ID, data []byte, numberKeys byte, threshold byte, timeoutSeconds int) (vdo VanashingDataObject) {
-	// TODO: Support shreshould
-	vdo = k.VanishData(data, numberKeys, threshold, 0)
-	if err := k.DoStoreVDO(id, vdo); err != nil {
-		fmt.Println("ERR: ", err)
+// Vanish : Shares and VDO both expire after timeoutSeconds, never if it's <= 0
+func (k *Kademlia) Vanish(id ID, data []byte, numberKeys byte, threshold byte, timeoutSeconds int) (vdo VanashingDataObject, err error) {
+	vdo = k.VanishData(data, numberKeys, threshold, timeoutSeconds)
+	if vdo.NumberKeys == 0 {
+		return vdo, &CommandFailed{"Vanish failed"}
 	}
-	return
+	if err = k.DoStoreVDOEx(id, vdo, int64(timeoutSeconds)); err != nil {
+		return vdo, &CommandFailed{"Storing VDO failed: " + err.Error()}
+	}
+	return vdo, nil
 }
 
+// DoStoreVDO : Publish the VDO on the k nodes closest to its ID, keeping a
+// local copy as well once one of them has it
 func (k *Kademlia) DoStoreVDO(id ID, vdo VanashingDataObject) error {
-	if vdo.NumberKeys > 0 {
-		k.DT.Add(id, vdo)
-		return nil
-	} else {
+	return k.DoStoreVDOEx(id, vdo, -1)
+}
+
+// DoStoreVDOEx : DoStoreVDO with expiration time in seconds. Fails if there
+// were nodes to store on and none of them accepted the VDO; a node that knows
+// no others keeps only the local copy.
+func (k *Kademlia) DoStoreVDOEx(id ID, vdo VanashingDataObject, exp_sec int64) error {
+	if vdo.NumberKeys == 0 {
 		return errors.New("too few numberKeys")
 	}
+	C, err := k.DoIterativeFindNode(id)
+	if err != nil {
+		return err
+	}
+	stored := 0
+	for i := 0; i < len(C); i++ {
+		if err = k.doStoreVDO(&C[i], id, vdo, exp_sec); err == nil {
+			stored++
+		}
+	}
+	if len(C) > 0 && stored == 0 {
+		return &CommandFailed{"No node stored the VDO: " + err.Error()}
+	}
+	// The local copy is a replica like the others
+	return k.DT.AddReplica(id, vdo, exp_sec)
+}
+
+func (k *Kademlia) doStoreVDO(contact *Contact, id ID, vdo VanashingDataObject, exp_sec int64) error {
+	client, err := k.dialContact(contact, "KademliaRPC.StoreVDO")
+	if err != nil {
+		return err
+	}
+	var reply StoreVDOResult
+	req := StoreVDORequest{k.SelfContact, id, vdo, NewRandomID(), exp_sec}
+	if err = k.call(client, "KademliaRPC.StoreVDO", req, &reply); err != nil {
+		return err
+	}
+	if reply.Err.Msg != "" {
+		return &reply.Err
+	}
+	return nil
 }
 
 func (k *Kademlia) doFindVDOAsync(contact Contact, searchKey ID, done chan GetVDOResult) error {
-	client, err := k.dial(contact.Host, contact.Port)
+	client, err := k.dialContact(&contact, "KademliaRPC.GetVDO")
 	if err != nil {
+		done <- GetVDOResult{Err: RPCError{err.Error()}}
 		return err
 	}
 	msgID := NewRandomID()
 	req := GetVDORequest{k.SelfContact, searchKey, msgID}
 	var reply GetVDOResult
-	if err := client.Call("KademliaRPC.GetVDO", req, &reply); err != nil {
+	if err := k.call(client, "KademliaRPC.GetVDO", req, &reply); err != nil {
+		done <- GetVDOResult{Err: RPCError{err.Error()}}
 		return err
 	}
 	done <- reply
 	return nil
 }
 
-func (k *Kademlia) Unvanish(nodeID ID, searchKey ID) (data []byte) {
-	V, err := k.DT.Find(searchKey)
+// GetVDO : Find a VDO by its ID, locally first and then on the nodes closest
+// to the ID. Malformed replies are dropped, and when replicas disagree the VDO
+// most of them returned wins.
+func (k *Kademlia) GetVDO(vdoID ID) (V VanashingDataObject, err error) {
+	V, err = k.DT.Find(vdoID)
 	if err == nil {
-		return k.UnvanishData(V)
-	} else {
-		C, err := k.DoIterativeFindNode(nodeID)
-		if err != nil {
-			return nil
+		return V, nil
+	}
+	C, err := k.DoIterativeFindNode(vdoID)
+	if err != nil {
+		return V, err
+	}
+	// Buffered so the slower replicas don't block once we have an answer
+	done := make(chan GetVDOResult, len(C))
+	for _, c := range C {
+		go k.doFindVDOAsync(c, vdoID, done)
+	}
+	// Answer as soon as a majority of the nodes agree, or else once all have
+	votes := make(map[[32]byte]int)
+	best := 0
+	for count := len(C); count > 0; count-- {
+		reply := <-done
+		if reply.Err.Msg != "" || !reply.VDO.valid() {
+			continue
 		}
-		done := make(chan GetVDOResult)
-		for _, c := range C {
-			go k.doFindVDOAsync(c, searchKey, done)
+		d := reply.VDO.digest()
+		votes[d]++
+		if votes[d] > best {
+			best = votes[d]
+			V = reply.VDO
 		}
-		count := len(C)
-		for count > 0 {
-			select {
-			case reply := <-done:
-				if reply.Err.Msg != "Key not found" {
-					V = reply.VDO
-					return k.UnvanishData(V)
-				}
-				count--
-			}
+		if best > len(C)/2 {
+			break
 		}
 	}
-	return nil
+	if best == 0 {
+		return V, errors.New("VDO not found")
+	}
+	return V, nil
+}
+
+func (k *Kademlia) Unvanish(vdoID ID) (data []byte, err error) {
+	V, err := k.GetVDO(vdoID)
+	if err != nil {
+		return nil, err
+	}
+	return k.UnvanishData(V)
 }
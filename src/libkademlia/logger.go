@@ -0,0 +1,85 @@
+package libkademlia
+
+// Leveled logging with key-value fields. A node logs through Config.Logger if
+// one is set, otherwise in the logfmt style to Config.LogFile or stderr. Every
+// line of a node carries its ID as "node"; RPCs add "op", "peer" and "msg_id".
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Level : How important a log line is
+type Level int
+
+const (
+	LevelDebug Level = iota - 1 // every RPC and lookup
+	LevelInfo                   // joining, failed RPCs
+	LevelWarn                   // failed join attempts
+	LevelError                  // lost data, bugs
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l-LevelDebug]
+}
+
+// ParseLevel : Level from its name, e.g. "debug"
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i) + LevelDebug, nil
+		}
+	}
+	return LevelInfo, &CommandFailed{"Unknown log level " + s}
+}
+
+// Logger : Where a node logs to. Fields alternate keys and values.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+	// With returns a Logger which adds fields to every line
+	With(fields ...interface{}) Logger
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewLogger : Logger writing lines of the form
+// time=... level=INFO msg="Joined" node=... peers=1 to w, dropping lines below
+// level
+func NewLogger(w io.Writer, level Level) Logger {
+	return slogLogger{slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.Level(4 * level),
+	}))}
+}
+
+// NewSlogLogger : Logger backed by l, e.g. for JSON output
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+func (s slogLogger) Debug(msg string, fields ...interface{}) { s.l.Debug(msg, fields...) }
+func (s slogLogger) Info(msg string, fields ...interface{})  { s.l.Info(msg, fields...) }
+func (s slogLogger) Warn(msg string, fields ...interface{})  { s.l.Warn(msg, fields...) }
+func (s slogLogger) Error(msg string, fields ...interface{}) { s.l.Error(msg, fields...) }
+
+func (s slogLogger) With(fields ...interface{}) Logger {
+	return slogLogger{s.l.With(fields...)}
+}
+
+// Logger : The node's logger, which drops everything if k is nil
+func (k *Kademlia) Logger() Logger {
+	if k == nil || k.logger == nil {
+		return slogLogger{slog.New(slog.NewTextHandler(io.Discard, nil))}
+	}
+	return k.logger
+}
@@ -0,0 +1,210 @@
+package libkademlia
+
+// Node statistics in the Prometheus text format, served at /metrics on the
+// node's HTTP server. Counters and histograms are kept here as events happen;
+// table sizes are read when the metrics are scraped.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	hopBuckets     = []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 20}
+)
+
+var metricHelp = map[string]string{
+	"kademlia_rpc_sent_total":              "RPCs sent, by method.",
+	"kademlia_rpc_failed_total":            "RPCs sent which failed to dial or call, by method.",
+	"kademlia_rpc_received_total":          "RPCs received, by method.",
+	"kademlia_rpc_duration_seconds":        "Time from sending an RPC to its reply, by method.",
+	"kademlia_lookups_total":               "Iterative lookups, by type.",
+	"kademlia_lookup_hops":                 "Rounds of alpha parallel RPCs per iterative lookup, by type.",
+	"kademlia_lookup_duration_seconds":     "Time taken by iterative lookups, by type.",
+	"kademlia_routing_table_contacts":      "Contacts in the routing table.",
+	"kademlia_routing_table_added_total":   "Contacts added to the routing table.",
+	"kademlia_routing_table_evicted_total": "Contacts evicted from full buckets.",
+	"kademlia_hash_table_values":           "Values in the hash table.",
+	"kademlia_hash_table_bytes":            "Bytes of values in the hash table.",
+	"kademlia_data_table_vdos":             "VDOs in the data table.",
+	"kademlia_data_table_bytes":            "Bytes of VDO ciphertext in the data table.",
+}
+
+type metricKey struct {
+	name   string
+	labels string // rendered, e.g. method="Ping"
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bound, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Metrics : Counters and histograms of one node, safe for concurrent use
+type Metrics struct {
+	mutex      sync.Mutex
+	counters   map[metricKey]float64
+	histograms map[metricKey]*histogram
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		counters:   make(map[metricKey]float64),
+		histograms: make(map[metricKey]*histogram),
+	}
+}
+
+func label(name, value string) string {
+	return fmt.Sprintf("%s=%q", name, value)
+}
+
+// Add : Add v to a counter
+func (m *Metrics) Add(name, labels string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	m.counters[metricKey{name, labels}] += v
+	m.mutex.Unlock()
+}
+
+// Observe : Record v in a histogram with the given bucket bounds
+func (m *Metrics) Observe(name, labels string, v float64, bounds []float64) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, ok := m.histograms[metricKey{name, labels}]
+	if !ok {
+		h = &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+		m.histograms[metricKey{name, labels}] = h
+	}
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// rpcSent : Count an RPC, failed if err isn't nil
+func (m *Metrics) rpcSent(method string, start time.Time, err error) {
+	labels := label("method", strings.TrimPrefix(method, "KademliaRPC."))
+	m.Add("kademlia_rpc_sent_total", labels, 1)
+	if err != nil {
+		m.Add("kademlia_rpc_failed_total", labels, 1)
+		return
+	}
+	m.Observe("kademlia_rpc_duration_seconds", labels, time.Since(start).Seconds(), latencyBuckets)
+}
+
+func (m *Metrics) rpcReceived(method string) {
+	m.Add("kademlia_rpc_received_total", label("method", method), 1)
+}
+
+func (m *Metrics) lookupDone(kind string, hops int, start time.Time) {
+	labels := label("type", kind)
+	m.Add("kademlia_lookups_total", labels, 1)
+	m.Observe("kademlia_lookup_hops", labels, float64(hops), hopBuckets)
+	m.Observe("kademlia_lookup_duration_seconds", labels, time.Since(start).Seconds(), latencyBuckets)
+}
+
+// write : Write the metrics, with the given gauges, in the Prometheus text
+// format
+func (m *Metrics) write(w io.Writer, gauges map[string]float64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	type sample struct {
+		key   metricKey
+		value float64
+	}
+	families := make(map[string][]sample)
+	kinds := make(map[string]string)
+	for key, v := range m.counters {
+		families[key.name] = append(families[key.name], sample{key, v})
+		kinds[key.name] = "counter"
+	}
+	for name, v := range gauges {
+		families[name] = append(families[name], sample{metricKey{name, ""}, v})
+		kinds[name] = "gauge"
+	}
+	for key := range m.histograms {
+		families[key.name] = append(families[key.name], sample{key, 0})
+		kinds[key.name] = "histogram"
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, metricHelp[name], name, kinds[name])
+		samples := families[name]
+		sort.Slice(samples, func(i, j int) bool { return samples[i].key.labels < samples[j].key.labels })
+		for _, s := range samples {
+			if kinds[name] != "histogram" {
+				fmt.Fprintf(&b, "%s%s %v\n", name, braces(s.key.labels), s.value)
+				continue
+			}
+			h := m.histograms[s.key]
+			var cumulative uint64
+			for i, bound := range h.bounds {
+				cumulative += h.counts[i]
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, braces(join(s.key.labels, label("le", fmt.Sprint(bound)))), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, braces(join(s.key.labels, label("le", "+Inf"))), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %v\n", name, braces(s.key.labels), h.sum)
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, braces(s.key.labels), h.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+// WriteMetrics : Write the node's metrics in the Prometheus text format
+func (k *Kademlia) WriteMetrics(w io.Writer) error {
+	ht := k.HT.Stats()
+	dt := k.DT.Stats()
+	return k.metrics.write(w, map[string]float64{
+		"kademlia_routing_table_contacts": float64(k.RT.Size()),
+		"kademlia_hash_table_values":      float64(ht.Entries),
+		"kademlia_hash_table_bytes":       float64(ht.Bytes),
+		"kademlia_data_table_vdos":        float64(dt.Entries),
+		"kademlia_data_table_bytes":       float64(dt.Bytes),
+	})
+}
+
+// serveMetrics : The /metrics endpoint
+func (k *Kademlia) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	k.WriteMetrics(w)
+}
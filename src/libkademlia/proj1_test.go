@@ -28,10 +28,46 @@ func StringToIpPort(laddr string) (ip net.IP, port uint16, err error) {
 	return
 }
 
+// newTestNode : NewKademlia, failing the test if the node can't start and
+// closing it when the test ends. Pass port 0 so tests never share a port.
+func newTestNode(t *testing.T, laddr string) *Kademlia {
+	t.Helper()
+	instance, err := NewKademlia(laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(instance.Finalize)
+	return instance
+}
+
+// newTestNodeWithId : NewKademliaWithId, failing the test if the node can't
+// start
+func newTestNodeWithId(t *testing.T, laddr string, nodeID ID) *Kademlia {
+	t.Helper()
+	instance, err := NewKademliaWithId(laddr, nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(instance.Finalize)
+	return instance
+}
+
+// freeAddr : A local address nothing listens on, for peers that are down or
+// start later
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
 func TestPing(t *testing.T) {
-	instance1 := NewKademlia("localhost:7890")
-	instance2 := NewKademlia("localhost:7891")
-	host2, port2, _ := StringToIpPort("localhost:7891")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	contact2, err := instance2.FindContact(instance2.NodeID)
 	if err != nil {
 		t.Error("A node cannot find itself's contact info")
@@ -69,9 +105,9 @@ func TestPing(t *testing.T) {
 
 func TestStore(t *testing.T) {
 	// test Dostore() function and LocalFindValue() function
-	instance1 := NewKademlia("localhost:7892")
-	instance2 := NewKademlia("localhost:7893")
-	host2, port2, _ := StringToIpPort("localhost:7893")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	instance1.DoPing(host2, port2)
 	contact2, err := instance1.FindContact(instance2.NodeID)
 	if err != nil {
@@ -104,9 +140,9 @@ func TestFindNode(t *testing.T) {
 	      \
 	         E
 	*/
-	instance1 := NewKademlia("localhost:7894")
-	instance2 := NewKademlia("localhost:7895")
-	host2, port2, _ := StringToIpPort("localhost:7895")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	instance1.DoPing(host2, port2)
 	contact2, err := instance1.FindContact(instance2.NodeID)
 	if err != nil {
@@ -115,9 +151,8 @@ func TestFindNode(t *testing.T) {
 	}
 	tree_node := make([]*Kademlia, 10)
 	for i := 0; i < 10; i++ {
-		address := "localhost:" + strconv.Itoa(7896+i)
-		tree_node[i] = NewKademlia(address)
-		host_number, port_number, _ := StringToIpPort(address)
+		tree_node[i] = newTestNode(t, "localhost:0")
+		host_number, port_number := tree_node[i].SelfContact.Host, tree_node[i].SelfContact.Port
 		instance2.DoPing(host_number, port_number)
 	}
 	key := NewRandomID()
@@ -160,9 +195,9 @@ func TestFindValue(t *testing.T) {
 	      \
 	         E
 	*/
-	instance1 := NewKademlia("localhost:7926")
-	instance2 := NewKademlia("localhost:7927")
-	host2, port2, _ := StringToIpPort("localhost:7927")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	instance1.DoPing(host2, port2)
 	contact2, err := instance1.FindContact(instance2.NodeID)
 	if err != nil {
@@ -172,9 +207,8 @@ func TestFindValue(t *testing.T) {
 
 	tree_node := make([]*Kademlia, 10)
 	for i := 0; i < 10; i++ {
-		address := "localhost:" + strconv.Itoa(7928+i)
-		tree_node[i] = NewKademlia(address)
-		host_number, port_number, _ := StringToIpPort(address)
+		tree_node[i] = newTestNode(t, "localhost:0")
+		host_number, port_number := tree_node[i].SelfContact.Host, tree_node[i].SelfContact.Port
 		instance2.DoPing(host_number, port_number)
 	}
 
@@ -279,9 +313,9 @@ func TestHashTable(t *testing.T) {
 }
 
 func TestFullBucket(t *testing.T) {
-	instance1 := NewKademlia("localhost:10001")
-	instance2 := NewKademlia("localhost:10002")
-	host2, port2, _ := StringToIpPort("localhost:10002")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	instance1.DoPing(host2, port2)
 	// instance2Size, instance2Info := instance2.GetRoutingTableInfo()
 	// fmt.Printf("%v\n%v\n", instance2Size, instance2Info)
@@ -297,9 +331,8 @@ func TestFullBucket(t *testing.T) {
 			nodeId = nodeId.Increse(1)
 		}
 		// fmt.Printf("%v  ", nodeId.Xor(firstNodeID))
-		address := "localhost:" + strconv.Itoa(10003+i)
-		tree_node[i] = NewKademliaWithId(address, nodeId)
-		host_number, port_number, _ := StringToIpPort(address)
+		tree_node[i] = newTestNodeWithId(t, "localhost:0", nodeId)
+		host_number, port_number := tree_node[i].SelfContact.Host, tree_node[i].SelfContact.Port
 		instance2.DoPing(host_number, port_number)
 		// instance2Size, instance2Info := instance2.GetRoutingTableInfo()
 		// fmt.Printf("%v\n%v\n", instance2Size, instance2Info)
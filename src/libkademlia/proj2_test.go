@@ -2,27 +2,35 @@ package libkademlia
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNodeLeave(t *testing.T) {
-	instance1 := NewKademlia("localhost:20001")
-	instance2 := NewKademlia("localhost:20002")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
 	//bufio.NewReader(os.Stdin).ReadBytes('\n')
-	host2, port2, _ := StringToIpPort("localhost:20002")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	_, err := instance1.DoPing(host2, port2)
 	if err != nil {
 		t.Error("Can't ping active peer")
 	}
-	host2, port2, _ = StringToIpPort("localhost:20001")
+	host2, port2 = instance1.SelfContact.Host, instance1.SelfContact.Port
 	_, err = instance2.DoPing(host2, port2)
 	if err != nil {
 		t.Error("Can't ping active peer")
 	}
 
-	host3, port3, _ := StringToIpPort("localhost:20003")
+	host3, port3, _ := StringToIpPort(freeAddr(t))
 	//bufio.NewReader(os.Stdin).ReadBytes('\n')
 	_, err = instance1.DoPing(host3, port3)
 	if err == nil {
@@ -31,9 +39,9 @@ func TestNodeLeave(t *testing.T) {
 }
 
 func TestIterativeFindNode(t *testing.T) {
-	instance1 := NewKademlia("localhost:7101")
-	instance2 := NewKademlia("localhost:7102")
-	host2, port2, _ := StringToIpPort("localhost:7102")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	_, err := instance1.DoPing(host2, port2)
 	if err != nil {
 		t.Error("Can't ping instance1")
@@ -43,9 +51,8 @@ func TestIterativeFindNode(t *testing.T) {
 	}
 	tree_node := make([]*Kademlia, 40)
 	for i := 0; i < 40; i++ {
-		address := "localhost:" + strconv.Itoa(7103+i)
-		tree_node[i] = NewKademlia(address)
-		host_number, port_number, _ := StringToIpPort(address)
+		tree_node[i] = newTestNode(t, "localhost:0")
+		host_number, port_number := tree_node[i].SelfContact.Host, tree_node[i].SelfContact.Port
 		_, err = instance2.DoPing(host_number, port_number)
 		if err != nil {
 			t.Error("Can't ping instance" + strconv.Itoa(i+3))
@@ -65,9 +72,9 @@ func TestIterativeFindNode(t *testing.T) {
 }
 
 func TestIterativeStore(t *testing.T) {
-	instance1 := NewKademlia("localhost:30001")
-	instance2 := NewKademlia("localhost:30002")
-	host2, port2, _ := StringToIpPort("localhost:30002")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	_, err := instance1.DoPing(host2, port2)
 	if err != nil {
 		t.Error("Can't ping instance1")
@@ -77,9 +84,8 @@ func TestIterativeStore(t *testing.T) {
 	}
 	tree_node := make([]*Kademlia, 40)
 	for i := 0; i < 40; i++ {
-		address := "localhost:" + strconv.Itoa(30003+i)
-		tree_node[i] = NewKademlia(address)
-		host_number, port_number, _ := StringToIpPort(address)
+		tree_node[i] = newTestNode(t, "localhost:0")
+		host_number, port_number := tree_node[i].SelfContact.Host, tree_node[i].SelfContact.Port
 		_, err = instance2.DoPing(host_number, port_number)
 		if err != nil {
 			t.Error("Can't ping instance" + strconv.Itoa(i+3))
@@ -110,9 +116,9 @@ func TestIterativeStore(t *testing.T) {
 }
 
 func TestIterativeFindValue(t *testing.T) {
-	instance1 := NewKademlia("localhost:40001")
-	instance2 := NewKademlia("localhost:40002")
-	host2, port2, _ := StringToIpPort("localhost:40002")
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
 	_, err := instance1.DoPing(host2, port2)
 	if err != nil {
 		t.Error("Can't ping instance1")
@@ -124,9 +130,8 @@ func TestIterativeFindValue(t *testing.T) {
 	}
 	tree_node := make([]*Kademlia, 40)
 	for i := 0; i < 40; i++ {
-		address := "localhost:" + strconv.Itoa(40003+i)
-		tree_node[i] = NewKademlia(address)
-		host_number, port_number, _ := StringToIpPort(address)
+		tree_node[i] = newTestNode(t, "localhost:0")
+		host_number, port_number := tree_node[i].SelfContact.Host, tree_node[i].SelfContact.Port
 		_, err = instance2.DoPing(host_number, port_number)
 		if err != nil {
 			t.Error("Can't ping instance" + strconv.Itoa(i+3))
@@ -158,7 +163,7 @@ func TestShortlist(t *testing.T) {
 	list.Init(nil, NewRandomID())
 	host, port, _ := StringToIpPort("localhost:10002")
 	for i := 0; i < 10; i++ {
-		C[i] = Contact{NewRandomID(), host, port}
+		C[i] = Contact{NewRandomID(), host, port, nil}
 		list.Add(C[i])
 	}
 	CA := list.GetActiveContact()
@@ -194,3 +199,570 @@ func TestShortlist(t *testing.T) {
 		}
 	}
 }
+
+func TestBootstrapRetry(t *testing.T) {
+	first := newTestNode(t, "localhost:0")
+	cfg := DefaultConfig()
+	cfg.Listen = "localhost:0"
+	cfg.Bootstrap = []string{freeAddr(t), first.ListenAddr().String()}
+	joiner, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := joiner.Bootstrap()
+	if status.State != JoinJoined || len(status.Peers) != 1 || !status.Peers[0].NodeID.Equals(first.NodeID) {
+		t.Error(fmt.Sprint("Should join through the live peer: ", status))
+	}
+
+	// Nobody there until later
+	later := freeAddr(t)
+	cfg.Bootstrap = []string{later}
+	cfg.BootstrapRetry = 100 * time.Millisecond
+	cfg.BootstrapMaxRetry = 200 * time.Millisecond
+	late, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := late.Bootstrap(); status.State != JoinPending || status.NextTry.IsZero() {
+		t.Error(fmt.Sprint("Should still be joining: ", status))
+	}
+	peer := newTestNode(t, later)
+	time.Sleep(time.Second)
+	if status := late.JoinStatus(); status.State != JoinJoined || status.Attempts < 2 {
+		t.Error(fmt.Sprint("Should have joined on a retry: ", status))
+	}
+	if _, err := late.FindContact(peer.NodeID); err != nil {
+		t.Error("Joined peer should be in the routing table")
+	}
+
+	self := newTestNode(t, "localhost:0")
+	self.Config.Bootstrap = []string{self.ListenAddr().String()}
+	if status := self.Bootstrap(); status.State != JoinStandalone {
+		t.Error(fmt.Sprint("Bootstrapping through itself is standalone: ", status))
+	}
+}
+
+func TestRoutingTableExport(t *testing.T) {
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
+	instance1.DoPing(host2, port2)
+
+	info := instance1.RT.Dump()
+	if len(info) != 1 || !info[0].NodeID.Equals(instance2.NodeID) || info[0].LastSeen.IsZero() {
+		t.Fatal(fmt.Sprint("Wrong dump: ", info))
+	}
+	instance1.RT.Failed(instance2.NodeID)
+	if info := instance1.RT.Dump(); info[0].Failures != 1 {
+		t.Error("Failure not counted")
+	}
+
+	var buf bytes.Buffer
+	if err := instance1.ExportRoutingTableJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Learns about both nodes from instance1's dump
+	instance3 := newTestNode(t, "localhost:0")
+	added, err := instance3.ImportRoutingTable(&buf, true)
+	if err != nil || added != 2 {
+		t.Error(fmt.Sprint("Wrong import: ", added, err))
+	}
+	if _, err := instance3.FindContact(instance2.NodeID); err != nil {
+		t.Error("Instance2 should be in instance3's table")
+	}
+
+	buf.Reset()
+	instance1.ExportRoutingTableDot(&buf)
+	if !bytes.Contains(buf.Bytes(), []byte(instance2.NodeID.AsString())) || !bytes.Contains(buf.Bytes(), []byte("color=red")) {
+		t.Error("Dot export missing contact: " + buf.String())
+	}
+}
+
+func TestImportFullBucket(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = "localhost:0"
+	cfg.NodeID = NewRandomID()
+	cfg.BucketSize = 1
+	cfg.Alpha = 1
+	instance3, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance3.Finalize()
+
+	// Both land in the same bucket of instance3, which has room for one
+	id1, id2 := CopyID(cfg.NodeID), CopyID(cfg.NodeID)
+	id1[0] ^= 0x80
+	id2[0] ^= 0x80
+	id2[IDBytes-1] ^= 1
+	instance1 := newTestNodeWithId(t, "localhost:0", id1)
+	instance2 := newTestNodeWithId(t, "localhost:0", id2)
+	instance1.DoPing(instance2.SelfContact.Host, instance2.SelfContact.Port)
+
+	var buf bytes.Buffer
+	if err := instance1.ExportRoutingTableJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	added, err := instance3.ImportRoutingTable(&buf, true)
+	if err != nil || added != 1 {
+		t.Error(fmt.Sprint("Wrong import: ", added, err))
+	}
+	if size := instance3.RT.Size(); size != 1 {
+		t.Error(fmt.Sprint("Routing table holds ", size, " contacts, want 1"))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
+	instance1.DoPing(host2, port2)
+	instance1.DoPing(host2, 1)
+	instance1.DoIterativeStore(NewRandomID(), []byte("hello"))
+
+	resp, err := http.Get("http://" + instance2.ListenAddr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, line := range []string{
+		`kademlia_rpc_received_total{method="Ping"} 1`,
+		`kademlia_rpc_received_total{method="FindNode"} 1`,
+		"kademlia_hash_table_values 1",
+		"kademlia_hash_table_bytes 5",
+		"kademlia_routing_table_contacts 1",
+	} {
+		if !bytes.Contains(body, []byte(line+"\n")) {
+			t.Error("instance2 metrics missing " + line + "\n" + string(body))
+		}
+	}
+
+	var buf bytes.Buffer
+	instance1.WriteMetrics(&buf)
+	for _, line := range []string{
+		`kademlia_rpc_sent_total{method="Ping"} 2`,
+		`kademlia_rpc_failed_total{method="Ping"} 1`,
+		`kademlia_rpc_duration_seconds_count{method="Store"} 1`,
+		`kademlia_lookups_total{type="node"} 1`,
+		`kademlia_lookup_hops_bucket{type="node",le="+Inf"} 1`,
+		"kademlia_routing_table_added_total 1",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(line+"\n")) {
+			t.Error("instance1 metrics missing " + line + "\n" + buf.String())
+		}
+	}
+}
+
+func TestBucketEviction(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = "localhost:0"
+	cfg.BucketSize = 2
+	cfg.Alpha = 1
+	instance1, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance1.Finalize()
+	evicted := instance1.Subscribe(10, EventContactEvicted)
+
+	// IDs that all land in the same bucket of instance1's table
+	sameBucket := func(i byte) ID {
+		id := CopyID(instance1.NodeID)
+		id[0] ^= 0x80
+		id[IDBytes-1] = i
+		return id
+	}
+	host := net.ParseIP("127.0.0.1")
+	dead1 := Contact{sameBucket(1), host, 1, nil}
+	dead2 := Contact{sameBucket(2), host, 1, nil}
+	instance1.RT.Update(dead1)
+	instance1.RT.Update(dead2)
+
+	// The head doesn't answer, so it makes room for the live node
+	live := newTestNodeWithId(t, "localhost:0", sameBucket(3))
+	if err := instance1.RT.Update(live.SelfContact); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance1.FindContact(dead1.NodeID); err == nil {
+		t.Error("Dead head should have been evicted")
+	}
+	dead3 := Contact{sameBucket(4), host, 1, nil}
+	if err := instance1.RT.Update(dead3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Now the live node is the head and answers, so the newcomer is dropped
+	if instance1.RT.Update(Contact{sameBucket(5), host, 1, nil}) == nil {
+		t.Error("Full bucket with a live head took a new contact")
+	}
+	if _, err := instance1.FindContact(live.NodeID); err != nil {
+		t.Error("Live head should have been kept")
+	}
+	if size := instance1.RT.Size(); size != 2 {
+		t.Error(fmt.Sprint("Bucket holds ", size, " contacts, want 2"))
+	}
+
+	var buf bytes.Buffer
+	instance1.WriteMetrics(&buf)
+	if !strings.Contains(buf.String(), "kademlia_routing_table_evicted_total 2\n") {
+		t.Error("Wrong eviction count\n" + buf.String())
+	}
+	for _, want := range []Contact{dead1, dead2} {
+		if len(evicted.C) == 0 {
+			t.Fatal("Missing eviction event")
+		}
+		if e := <-evicted.C; !e.Contact.NodeID.Equals(want.NodeID) {
+			t.Error(fmt.Sprint("Wrong contact evicted: ", e.Contact))
+		}
+	}
+	if len(evicted.C) != 0 {
+		t.Error("Live contact evicted")
+	}
+}
+
+// lockedBuffer : bytes.Buffer safe for the node's goroutines to log to
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestLogger(t *testing.T) {
+	var debug, warn lockedBuffer
+	cfg := DefaultConfig()
+	cfg.Listen = "localhost:0"
+	cfg.Logger = NewLogger(&debug, LevelDebug)
+	instance1, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Logger = NewLogger(&warn, LevelWarn)
+	instance2, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
+	instance1.DoPing(host2, port2)
+	instance1.DoPing(host2, 1)
+
+	for _, field := range []string{
+		"level=DEBUG msg=\"RPC sent\" node=" + instance1.NodeID.AsString() + " op=Ping peer=" + instance2.ListenAddr().String() + " msg_id=",
+		"level=INFO msg=\"RPC failed\" node=" + instance1.NodeID.AsString() + " op=Ping peer=127.0.0.1:1",
+	} {
+		if !strings.Contains(debug.String(), field) {
+			t.Error("instance1 log missing " + field + "\n" + debug.String())
+		}
+	}
+	if warn.String() != "" {
+		t.Error("instance2 logged below warn\n" + warn.String())
+	}
+	instance2.Finalize()
+	instance1.Finalize()
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Unknown level should fail")
+	}
+	if level, _ := ParseLevel("WARN"); level != LevelWarn {
+		t.Error("Wrong level " + level.String())
+	}
+}
+
+func TestLookupTrace(t *testing.T) {
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	instance3 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
+	host3, port3 := instance3.SelfContact.Host, instance3.SelfContact.Port
+	instance1.DoPing(host2, port2)
+	instance2.DoPing(host3, port3)
+	// A contact that never answers
+	instance1.RT.Update(Contact{NewRandomID(), host2, 1, nil})
+
+	key := NewRandomID()
+	instance3.HT.Add(key, []byte("traced"))
+	value, trace, err := instance1.DoIterativeFindValueTrace(key)
+	if err != nil || string(value) != "traced" {
+		t.Fatal("Traced lookup failed", err)
+	}
+	if !trace.Found || trace.Kind != "value" || trace.Target != key.AsString() || len(trace.Rounds) < 2 {
+		t.Fatal(fmt.Sprint("Wrong trace: ", trace))
+	}
+	results := make(map[string]int)
+	for _, r := range trace.Rounds {
+		for _, q := range r.Queried {
+			results[q.Result]++
+		}
+	}
+	if results["value"] != 1 || results["error"] != 1 || results["ok"] < 1 {
+		t.Error(fmt.Sprint("Wrong query results: ", results))
+	}
+
+	contacts, trace, err := instance1.DoIterativeFindNodeTrace(instance3.NodeID)
+	if err != nil || trace.Contacts != len(contacts) || len(trace.Rounds) == 0 {
+		t.Error(fmt.Sprint("Wrong node trace: ", trace, err))
+	}
+	var buf bytes.Buffer
+	trace.WriteReport(&buf)
+	if !strings.Contains(buf.String(), "round 1 (alpha)") {
+		t.Error("Report missing rounds\n" + buf.String())
+	}
+	t.Log("\n" + buf.String())
+}
+
+func TestEvents(t *testing.T) {
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	all := instance2.Subscribe(100)
+	stored := instance2.Subscribe(1, EventValueStored)
+
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
+	contact2, _ := instance1.DoPing(host2, port2)
+	for i := 0; i < 3; i++ {
+		instance1.DoStore(contact2, NewRandomID(), []byte("event"))
+	}
+	key := NewRandomID()
+	instance2.HT.AddEx(key, []byte("short"), 1)
+	instance2.DT.AddEx(key, VanashingDataObject{}, 1)
+	time.Sleep(1100 * time.Millisecond)
+	instance2.HT.Find(key)
+	instance2.DT.Find(key)
+
+	kinds := make(map[EventKind]int)
+	for len(all.C) > 0 {
+		e := <-all.C
+		kinds[e.Kind]++
+		if e.Kind == EventRPCReceived && !e.Contact.NodeID.Equals(instance1.NodeID) {
+			t.Error("RPC from the wrong sender")
+		}
+	}
+	want := map[EventKind]int{
+		EventRPCReceived:  4,
+		EventContactAdded: 1,
+		EventValueStored:  4,
+		EventValueExpired: 1,
+		EventVDOStored:    1,
+		EventVDOExpired:   1,
+	}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Error(fmt.Sprintf("%d %s events, want %d", kinds[kind], kind, n))
+		}
+	}
+	if all.Dropped() != 0 {
+		t.Error("Dropped events with room to spare")
+	}
+
+	// The full subscription keeps the first event and counts the rest
+	if e := <-stored.C; e.Kind != EventValueStored || stored.Dropped() != 3 {
+		t.Error(fmt.Sprint("Wrong backpressure: ", e, stored.Dropped()))
+	}
+	stored.Close()
+	if _, ok := <-stored.C; ok {
+		t.Error("Closed subscription still open")
+	}
+	instance2.Finalize()
+	if _, ok := <-all.C; ok {
+		t.Error("Subscription open after Finalize")
+	}
+	instance1.Finalize()
+}
+
+func TestClose(t *testing.T) {
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	host2, port2 := instance2.SelfContact.Host, instance2.SelfContact.Port
+	if _, err := instance1.DoPing(host2, port2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client that never hangs up holds Close until its context is done
+	client, err := rpc.DialHTTPPath("tcp", instance2.ListenAddr().String(), rpc.DefaultRPCPath+fmt.Sprint(port2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := instance2.Close(ctx); err != context.DeadlineExceeded {
+		t.Error(fmt.Sprint("Close didn't wait for the open connection: ", err))
+	}
+	var pong PongMessage
+	if client.Call("KademliaRPC.Ping", PingMessage{instance1.SelfContact, NewRandomID()}, &pong) == nil {
+		t.Error("Connection still served after Close")
+	}
+	if instance2.Close(context.Background()) != nil {
+		t.Error("Second Close should do nothing")
+	}
+	if _, err := instance1.DoPing(host2, port2); err == nil {
+		t.Error("Closed node answered a ping")
+	}
+	if instance2.RT.Update(instance1.SelfContact) == nil {
+		t.Error("Closed routing table accepted an update")
+	}
+
+	// The port is free again
+	cfg := DefaultConfig()
+	cfg.Listen = instance2.ListenAddr().String()
+	instance2, err = NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance1.DoPing(host2, port2); err != nil {
+		t.Error("Restarted node didn't answer: ", err)
+	}
+	if err := instance2.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	instance1.Close(context.Background())
+}
+
+func TestNodesShareProcess(t *testing.T) {
+	// Same port on two interfaces
+	instance1 := newTestNode(t, "127.0.0.1:0")
+	port := instance1.SelfContact.Port
+	instance2 := newTestNode(t, fmt.Sprint("127.0.0.2:", port))
+	if _, err := NewKademlia(instance1.ListenAddr().String()); err == nil {
+		t.Error("Busy port should fail")
+	}
+	pong, err := instance1.DoPing(net.ParseIP("127.0.0.2"), port)
+	if err != nil || !pong.NodeID.Equals(instance2.NodeID) {
+		t.Error("Ping reached the wrong node: ", err)
+	}
+
+	// Handlers on the default mux aren't served by nodes
+	http.HandleFunc("/shared", func(w http.ResponseWriter, r *http.Request) {})
+	resp, err := http.Get("http://" + instance1.ListenAddr().String() + "/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Node served the default mux")
+	}
+	instance1.Finalize()
+	instance2.Finalize()
+}
+
+func TestEphemeralPort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = "127.0.0.1:0"
+	instance1, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance2, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := instance2.SelfContact
+	if self.Port == 0 || !self.Host.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatal(fmt.Sprint("Wrong self contact: ", self))
+	}
+	if pong, err := instance1.DoPing(self.Host, self.Port); err != nil || pong.Port != self.Port {
+		t.Error(fmt.Sprint("Ping to the picked port failed: ", pong, err))
+	}
+
+	// Behind a port forward, peers reach us at the advertised address, and
+	// name the advertised port in the RPC path
+	cfg.Advertise = "localhost:7000"
+	instance3, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance3.SelfContact.Port != 7000 || !instance3.SelfContact.Host.Equal(net.ParseIP("127.0.0.1")) {
+		t.Error(fmt.Sprint("Advertised address ignored: ", instance3.SelfContact))
+	}
+	client, err := rpc.DialHTTPPath("tcp", instance3.ListenAddr().String(), rpc.DefaultRPCPath+"7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pong PongMessage
+	if err := client.Call("KademliaRPC.Ping", PingMessage{instance1.SelfContact, NewRandomID()}, &pong); err != nil {
+		t.Error(err)
+	}
+	client.Close()
+
+	for _, bad := range []string{"a:b:c", "host:0", "host:port", "[::1"} {
+		cfg.Advertise = bad
+		if cfg.Validate() == nil {
+			t.Error("Bad advertised address accepted: " + bad)
+		}
+	}
+	instance1.Finalize()
+	instance2.Finalize()
+	instance3.Finalize()
+}
+
+func TestIPv6(t *testing.T) {
+	// A port free on 127.0.0.1, so only the IPv6 node answers there
+	_, free, _ := net.SplitHostPort(freeAddr(t))
+	cfg := DefaultConfig()
+	cfg.Listen = "[::1]:" + free
+	instance6, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen = "127.0.0.1:0"
+	instance4, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self := instance6.SelfContact
+	if !self.Host.Equal(net.IPv6loopback) || len(self.AltHosts) != 0 {
+		t.Fatal(fmt.Sprint("Wrong self contact: ", self))
+	}
+
+	addr := net.JoinHostPort("::1", strconv.Itoa(int(self.Port)))
+	hosts, port, err := ResolveHostPort(addr)
+	if err != nil || len(hosts) != 1 || !hosts[0].Equal(net.IPv6loopback) || port != self.Port {
+		t.Error(fmt.Sprint("Resolved ", addr, " to ", hosts, port, err))
+	}
+	if pong, err := instance4.DoPingAddr(addr); err != nil || !pong.NodeID.Equals(self.NodeID) {
+		t.Fatal(fmt.Sprint("Ping over IPv6 failed: ", pong, err))
+	}
+	c, err := instance4.FindContact(self.NodeID)
+	if err != nil || !c.Host.Equal(net.IPv6loopback) {
+		t.Error(fmt.Sprint("IPv6 contact not in the routing table: ", c, err))
+	}
+
+	// Nothing listens on the IPv4 address, so RPCs fall back to the IPv6 one
+	dual := Contact{self.NodeID, net.ParseIP("127.0.0.1"), self.Port, []net.IP{net.IPv6loopback}}
+	if _, err := instance4.DoFindNode(&dual, NewRandomID()); err != nil {
+		t.Error("Dual-stack contact unreachable: ", err)
+	}
+	dual.AltHosts = nil
+	if _, err := instance4.DoFindNode(&dual, NewRandomID()); err == nil {
+		t.Error("Reached a contact at an address nothing listens on")
+	}
+
+	// A node with only IPv4 tries IPv4 addresses first
+	remote6, remote4 := net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")
+	if p := instance4.preferred([]net.IP{remote6, remote4}); !p[0].Equal(remote4) {
+		t.Error(fmt.Sprint("Wrong address order: ", p))
+	}
+	if p := instance6.preferred([]net.IP{remote4, remote6, net.IPv6loopback}); !p[0].Equal(remote6) {
+		t.Error(fmt.Sprint("Wrong address order: ", p))
+	}
+
+	// Alternative addresses survive a routing table dump
+	instance6.RT.Update(Contact{NewRandomID(), remote4, 1, []net.IP{remote6}})
+	var buf bytes.Buffer
+	instance6.ExportRoutingTableJSON(&buf)
+	if !strings.Contains(buf.String(), `"alt_hosts": [`) {
+		t.Error("Dump lost the alternative addresses: ", buf.String())
+	}
+	instance4.Finalize()
+	instance6.Finalize()
+}
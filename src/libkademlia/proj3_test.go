@@ -2,48 +2,69 @@ package libkademlia
 
 import (
 	"bytes"
+	"encoding/gob"
 	"fmt"
+	"io/ioutil"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"sss"
 	"strconv"
 	"testing"
+	"time"
 )
 
-func TestGetVDORPC(t *testing.T) {
-	instance1 := NewKademlia("localhost:9400")
-	instance2 := NewKademlia("localhost:9401")
-	//bufio.NewReader(os.Stdin).ReadBytes('\n')
-	host2, port2, _ := StringToIpPort("localhost:9401")
-	_, err := instance1.DoPing(host2, port2)
-	if err != nil {
-		t.Error("Can't ping instance2")
+// newTestNetwork : instance1 knows instance2, which knows 10 more nodes
+func newTestNetwork(t *testing.T) (instance1, instance2 *Kademlia, treeNode []*Kademlia) {
+	t.Helper()
+	instance1 = newTestNode(t, "localhost:0")
+	instance2 = newTestNode(t, "localhost:0")
+	if _, err := instance1.DoPing(instance2.SelfContact.Host, instance2.SelfContact.Port); err != nil {
+		t.Fatal("Can't ping instance 2")
 	}
-	treeNode := make([]*Kademlia, 10)
-	for i := 0; i < 10; i++ {
-		address := "localhost:" + strconv.Itoa(9402+i)
-		treeNode[i] = NewKademlia(address)
-		hostNumber, portNumber, _ := StringToIpPort(address)
-		_, err = instance2.DoPing(hostNumber, portNumber)
-		if err != nil {
-			t.Error("Can't ping instance" + strconv.Itoa(i+3))
+	treeNode = make([]*Kademlia, 10)
+	for i := range treeNode {
+		treeNode[i] = newTestNode(t, "localhost:0")
+		if _, err := instance2.DoPing(treeNode[i].SelfContact.Host, treeNode[i].SelfContact.Port); err != nil {
+			t.Fatal("Can't ping instance" + strconv.Itoa(i+3))
 		}
 	}
+	return
+}
+
+// waitUntil : Polls cond until it holds, giving up after timeout
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+func TestGetVDORPC(t *testing.T) {
+	instance1, instance2, _ := newTestNetwork(t)
 
 	key, _ := IDFromString("Hello")
 	data := []byte("World!")
 	numberKeys := byte(10)
 	threshold := byte(7)
-	VDO := instance2.Vanish(key, data, numberKeys, threshold, 0)
-	if VDO.NumberKeys <= 0 {
-		t.Error("Vanish failed!")
+	VDO, err := instance2.Vanish(key, data, numberKeys, threshold, 0)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	peerStr := host2.String() + ":" + strconv.Itoa(int(port2))
-	portStr := fmt.Sprint(port2)
+	peerStr := instance2.ListenAddr().String()
+	portStr := fmt.Sprint(instance2.SelfContact.Port)
 	client, err := rpc.DialHTTPPath("tcp", peerStr, rpc.DefaultRPCPath+portStr)
 
 	if err != nil {
-		t.Error("Can't dial instance2")
+		t.Fatal("Can't dial instance2")
 	}
+	// instance2 waits for its clients to hang up when it closes
+	defer client.Close()
 	msgID := NewRandomID()
 	req := GetVDORequest{instance1.SelfContact, key, msgID}
 	var reply GetVDOResult
@@ -60,33 +81,19 @@ func TestGetVDORPC(t *testing.T) {
 }
 
 func TestStoreVDO(t *testing.T) {
-	instance1 := NewKademlia("localhost:9500")
-	instance2 := NewKademlia("localhost:9501")
-	//bufio.NewReader(os.Stdin).ReadBytes('\n')
-	host2, port2, _ := StringToIpPort("localhost:9501")
-	_, err := instance1.DoPing(host2, port2)
-	if err != nil {
-		t.Error("Can't ping instance 2")
-	}
-	treeNode := make([]*Kademlia, 10)
-	for i := 0; i < 10; i++ {
-		address := "localhost:" + strconv.Itoa(9502+i)
-		treeNode[i] = NewKademlia(address)
-		hostNumber, portNumber, _ := StringToIpPort(address)
-		_, err = instance2.DoPing(hostNumber, portNumber)
-		if err != nil {
-			t.Error("Can't ping instance" + strconv.Itoa(i+3))
-		}
-	}
+	_, instance2, _ := newTestNetwork(t)
 	key, _ := IDFromString("Hello")
 	data := []byte("World!")
 	numberKeys := byte(10)
 	threshold := byte(7)
-	VDO := instance2.Vanish(key, data, numberKeys, threshold, 0)
-	if VDO.NumberKeys <= 0 {
-		t.Error("Vanish failed!")
+	_, err := instance2.Vanish(key, data, numberKeys, threshold, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := instance2.Unvanish(key)
+	if err != nil {
+		t.Error(err)
 	}
-	ret := instance2.Unvanish(instance2.NodeID, key)
 	if bytes.Compare(ret, data) != 0 {
 		t.Error("Unvanish failed!")
 		t.Error(fmt.Sprintln("Expect: ", data))
@@ -95,31 +102,14 @@ func TestStoreVDO(t *testing.T) {
 }
 
 func TestRetriveVDOFromOtherNode(t *testing.T) {
-	instance1 := NewKademlia("localhost:9600")
-	instance2 := NewKademlia("localhost:9601")
-	//bufio.NewReader(os.Stdin).ReadBytes('\n')
-	host2, port2, _ := StringToIpPort("localhost:9601")
-	_, err := instance1.DoPing(host2, port2)
-	if err != nil {
-		t.Error("Can't ping instance 2")
-	}
-	treeNode := make([]*Kademlia, 10)
-	for i := 0; i < 10; i++ {
-		address := "localhost:" + strconv.Itoa(9602+i)
-		treeNode[i] = NewKademlia(address)
-		hostNumber, portNumber, _ := StringToIpPort(address)
-		_, err = instance2.DoPing(hostNumber, portNumber)
-		if err != nil {
-			t.Error("Can't ping instance" + strconv.Itoa(i+3))
-		}
-	}
+	_, instance2, treeNode := newTestNetwork(t)
 	key, _ := IDFromString("Hello")
 	data := []byte("World!")
 	numberKeys := byte(10)
 	threshold := byte(7)
-	VDO := instance2.Vanish(key, data, numberKeys, threshold, 0)
-	if VDO.NumberKeys <= 0 {
-		t.Error("Vanish failed!")
+	VDO, err := instance2.Vanish(key, data, numberKeys, threshold, 0)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	treeNode[0].DoStoreVDO(key, VDO)
@@ -138,10 +128,329 @@ func TestRetriveVDOFromOtherNode(t *testing.T) {
 		t.Error("Remote node return wrong VDO!")
 	}
 
-	ret := instance2.Unvanish(instance2.NodeID, key)
+	ret, err := instance2.Unvanish(key)
+	if err != nil {
+		t.Error(err)
+	}
 	if bytes.Compare(ret, data) != 0 {
 		t.Error("Unvanish from remote node failed!")
 		t.Error(fmt.Sprintln("Expect: ", data))
 		t.Error(fmt.Sprintln("GOT: ", ret))
 	}
 }
+
+func TestVDOReplicated(t *testing.T) {
+	instance1, instance2, treeNode := newTestNetwork(t)
+	key := NewRandomID()
+	data := []byte("Replicated")
+	_, err := instance2.Vanish(key, data, 10, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicas := 0
+	for i := 0; i < 10; i++ {
+		if _, err := treeNode[i].DT.Find(key); err == nil {
+			replicas++
+		}
+	}
+	if replicas < 3 {
+		t.Error(fmt.Sprint("VDO not replicated, found on ", replicas, " nodes"))
+	}
+
+	// The creator forgets the VDO, anyone else can still find it
+	instance2.DT.Remove(key)
+	ret, err := instance1.Unvanish(key)
+	if err != nil {
+		t.Error(err)
+	}
+	if bytes.Compare(ret, data) != 0 {
+		t.Error("Unvanish without creator failed!")
+		t.Error(fmt.Sprintln("Expect: ", data))
+		t.Error(fmt.Sprintln("GOT: ", ret))
+	}
+
+	if _, err := instance1.GetVDO(NewRandomID()); err == nil {
+		t.Error("GetVDO should fail for unknown VDO")
+	}
+}
+
+func TestVDOReplicaIntegrity(t *testing.T) {
+	instance1, instance2, treeNode := newTestNetwork(t)
+	key := NewRandomID()
+	data := []byte("Original")
+	VDO, err := instance2.Vanish(key, data, 10, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No replica lets another VDO replace it, so storing one fails
+	other := instance1.VanishData([]byte("Forged"), 10, 7, 0)
+	if err := instance1.DoStoreVDO(key, other); err == nil {
+		t.Error("Overwriting the VDO on every replica should fail")
+	}
+	for i := 0; i < 10; i++ {
+		if vdo, err := treeNode[i].DT.Find(key); err == nil && vdo.digest() != VDO.digest() {
+			t.Error("Replica was overwritten")
+		}
+	}
+	if vdo, err := instance1.DT.Find(key); err == nil && vdo.digest() != VDO.digest() {
+		t.Error("VDO nobody accepted was kept locally")
+	}
+
+	// One replica lying is outvoted
+	treeNode[0].DT.Add(key, other)
+	vdo, err := instance1.GetVDO(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vdo.digest() != VDO.digest() {
+		t.Error("GetVDO returned the forged VDO")
+	}
+}
+
+func TestUnvanishBadShares(t *testing.T) {
+	instance1, instance2, treeNode := newTestNetwork(t)
+	nodes := append(treeNode, instance1, instance2)
+
+	key := NewRandomID()
+	data := []byte("World!")
+	VDO, err := instance2.Vanish(key, data, 10, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt one share, with a valid encoding so only its hash catches it,
+	// and make another one malformed everywhere
+	addrs := CalculateSharedKeyLocations(VDO.AccessKey, int64(VDO.NumberKeys))
+	for _, n := range nodes {
+		if good, err := n.LocalFindValue(addrs[0]); err == nil {
+			bad, _ := sss.ParseShare(good)
+			bad.Value[0] ^= 0xff
+			n.HT.Add(addrs[0], bad.Marshal())
+		}
+		if _, err := n.LocalFindValue(addrs[1]); err == nil {
+			n.HT.Add(addrs[1], []byte{})
+		}
+	}
+	ret, err := instance2.UnvanishData(VDO)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(ret, data) {
+		t.Error("Unvanish with bad shares failed!")
+		t.Error(fmt.Sprintln("Expect: ", data))
+		t.Error(fmt.Sprintln("GOT: ", ret))
+	}
+
+	// Drop two more, leaving 6 good shares for a threshold of 7. The corrupted
+	// one fails verification and isn't counted
+	for _, n := range nodes {
+		n.HT.Remove(addrs[2])
+		n.HT.Remove(addrs[3])
+	}
+	ret, err = instance2.UnvanishData(VDO)
+	if ret != nil {
+		t.Error("Unvanish should fail without enough shares")
+	}
+	serr, ok := err.(*ShareRetrievalError)
+	if !ok {
+		t.Error(fmt.Sprint("Unexpected error: ", err))
+	} else if serr.Needed != 7 || serr.Found != 6 {
+		t.Error(fmt.Sprint("Wrong share count: ", serr))
+	}
+
+	// Shares can't be checked without their hashes
+	VDO.ShareHashes = nil
+	if _, err := instance2.UnvanishData(VDO); err == nil || err.Error() != "Invalid VDO" {
+		t.Error(fmt.Sprint("VDO without share hashes should be invalid: ", err))
+	}
+}
+
+func TestDataTableExpire(t *testing.T) {
+	var D DataTable
+	var self *Kademlia
+	ida := NewRandomID()
+	idb := NewRandomID()
+	D.Init(self)
+	defer D.Finalize()
+	D.AddEx(ida, VanashingDataObject{AccessKey: 1}, 1)
+	D.Add(idb, VanashingDataObject{AccessKey: 2})
+	if _, err := D.Find(ida); err != nil {
+		t.Error("Id a should be in table")
+	}
+	// The sweeper removes it without anyone calling Find
+	swept := waitUntil(2500*time.Millisecond, func() bool {
+		D.Mutex.Lock()
+		defer D.Mutex.Unlock()
+		_, ok := D.Table[ida]
+		return !ok
+	})
+	if !swept {
+		t.Error("Id a should have been swept")
+	}
+	if _, err := D.Find(idb); err != nil {
+		t.Error("Id b never expires")
+	}
+}
+
+func TestVanishTimeout(t *testing.T) {
+	instance1, instance2, _ := newTestNetwork(t)
+	key := NewRandomID()
+	data := []byte("Short lived")
+	VDO, err := instance2.Vanish(key, data, 10, 7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := instance1.Unvanish(key)
+	if err != nil || !bytes.Equal(ret, data) {
+		t.Error(fmt.Sprint("Unvanish before timeout failed: ", err))
+	}
+
+	expired := waitUntil(2500*time.Millisecond, func() bool {
+		_, err := instance1.GetVDO(key)
+		return err != nil
+	})
+	if !expired {
+		t.Error("VDO should have expired")
+	}
+	if _, err := instance2.UnvanishData(VDO); err == nil {
+		t.Error("Shares should have expired")
+	}
+}
+
+func TestCachedValueExpiry(t *testing.T) {
+	instance1 := newTestNode(t, "localhost:0")
+	instance2 := newTestNode(t, "localhost:0")
+	instance3 := newTestNode(t, "localhost:0")
+	instance1.DoPing(instance2.SelfContact.Host, instance2.SelfContact.Port)
+	instance1.DoPing(instance3.SelfContact.Host, instance3.SelfContact.Port)
+
+	// Only instance3 has the value, so the lookup caches it on instance2
+	key := NewRandomID()
+	instance3.HT.AddEx(key, []byte("short lived"), 3)
+	if _, err := instance1.DoIterativeFindValue(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := instance2.LocalFindValue(key); err != nil {
+		t.Fatal("Value not cached")
+	}
+
+	// The copy expires with at most the 2 whole seconds the value had left
+	expired := waitUntil(2500*time.Millisecond, func() bool {
+		_, err := instance2.LocalFindValue(key)
+		return err != nil
+	})
+	if !expired {
+		t.Error("Cached copy outlived the value's timeout")
+	}
+	if _, err := instance3.LocalFindValue(key); err != nil {
+		t.Error("Value expired early")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.json")
+	ioutil.WriteFile(path, []byte(`{"listen": "localhost:9950", "alpha": 5,
+		"rpc_timeout": "500ms", "bootstrap": ["localhost:9951"], "log_level": "debug"}`), 0600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "localhost:9950" || cfg.Alpha != 5 || cfg.BucketSize != k ||
+		cfg.RPCTimeout != 500*time.Millisecond || len(cfg.Bootstrap) != 1 || cfg.LogLevel != LevelDebug {
+		t.Error(fmt.Sprint("Wrong config: ", cfg))
+	}
+
+	ioutil.WriteFile(path, []byte(`{"listen": "localhost:9950", "alhpa": 5}`), 0600)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Unknown option should fail")
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "node.yaml")); err == nil {
+		t.Error("YAML config should fail")
+	}
+	cfg = DefaultConfig()
+	cfg.Listen = "localhost:9950"
+	cfg.Alpha = cfg.BucketSize + 1
+	if _, err := NewKademliaFromConfig(cfg); err == nil {
+		t.Error("Alpha larger than k should fail")
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = "localhost:0"
+	cfg.DataDir = t.TempDir()
+	cfg.StoreTTL = time.Hour
+	instance1, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := NewRandomID()
+	instance1.HT.Add(key, []byte("kept"))
+	vdoID := NewRandomID()
+	instance1.DT.Add(vdoID, VanashingDataObject{AccessKey: 7})
+	instance1.Finalize()
+
+	// Same data dir, different port
+	cfg.Listen = "localhost:0"
+	instance2, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance2.Finalize()
+	if v, err := instance2.LocalFindValue(key); err != nil || string(v) != "kept" {
+		t.Error("Value not restored")
+	}
+	if vdo, err := instance2.DT.Find(vdoID); err != nil || vdo.AccessKey != 7 {
+		t.Error("VDO not restored")
+	}
+}
+
+func TestDataDirExpiry(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = "localhost:0"
+	cfg.DataDir = t.TempDir()
+	instance1, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := NewRandomID()
+	instance1.HT.Add(kept, []byte("kept"))
+	share := NewRandomID()
+	instance1.HT.AddEx(share, []byte("share"), 1)
+	instance1.Finalize()
+
+	// The share is loaded again and must leave the file once it expires.
+	// Reading it then deletes it, and the next sweep saves that.
+	cfg.Listen = "localhost:0"
+	cfg.SweepInterval = time.Hour
+	instance2, err := NewKademliaFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance2.Finalize()
+	expired := waitUntil(2*time.Second, func() bool {
+		_, err := instance2.LocalFindValue(share)
+		return err != nil
+	})
+	if !expired {
+		t.Error("Expired share still found")
+	}
+	instance2.HT.Delegate(HASH_TABLE_EVENT_SWEEP, HashTableEventArg{})
+	f, err := os.Open(filepath.Join(cfg.DataDir, valuesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var table map[ID]HashTableEntry
+	if err := gob.NewDecoder(f).Decode(&table); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := table[share]; ok {
+		t.Error("Expired share still on disk")
+	}
+	if _, ok := table[kept]; !ok {
+		t.Error("Value without expiry dropped from disk")
+	}
+}
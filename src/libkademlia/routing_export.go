@@ -0,0 +1,159 @@
+package libkademlia
+
+// Writing the routing table out as JSON or Graphviz, and reading contacts back
+// in from a JSON dump, e.g. to seed a node with another node's view of the
+// network.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// RoutingTableDump : JSON form of a node's routing table
+type RoutingTableDump struct {
+	NodeID   string        `json:"node_id"`
+	Host     string        `json:"host"`
+	AltHosts []string      `json:"alt_hosts,omitempty"`
+	Port     uint16        `json:"port"`
+	Time     time.Time     `json:"time"`
+	Contacts []ContactDump `json:"contacts"`
+}
+
+// ContactDump : JSON form of a ContactInfo, LastSeen is zero if unknown
+type ContactDump struct {
+	Bucket   int       `json:"bucket"`
+	ID       string    `json:"id"`
+	Host     string    `json:"host"`
+	AltHosts []string  `json:"alt_hosts,omitempty"`
+	Port     uint16    `json:"port"`
+	LastSeen time.Time `json:"last_seen"`
+	Failures int       `json:"failures"`
+}
+
+// DumpRoutingTable : Every contact by bucket, least recently seen first
+func (k *Kademlia) DumpRoutingTable() RoutingTableDump {
+	dump := RoutingTableDump{
+		NodeID:   k.NodeID.AsString(),
+		Host:     k.SelfContact.Host.String(),
+		AltHosts: ipStrings(k.SelfContact.AltHosts),
+		Port:     k.SelfContact.Port,
+		Time:     time.Now(),
+		Contacts: []ContactDump{},
+	}
+	for _, c := range k.RT.Dump() {
+		dump.Contacts = append(dump.Contacts, ContactDump{
+			Bucket:   c.Bucket,
+			ID:       c.NodeID.AsString(),
+			Host:     c.Host.String(),
+			AltHosts: ipStrings(c.AltHosts),
+			Port:     c.Port,
+			LastSeen: c.LastSeen,
+			Failures: c.Failures,
+		})
+	}
+	return dump
+}
+
+// ExportRoutingTableJSON : Write the routing table as indented JSON
+func (k *Kademlia) ExportRoutingTableJSON(w io.Writer) error {
+	out, err := json.MarshalIndent(k.DumpRoutingTable(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// ExportRoutingTableDot : Write the routing table as a Graphviz digraph, one
+// cluster per bucket and contacts with failures in red
+func (k *Kademlia) ExportRoutingTableDot(w io.Writer) error {
+	dump := k.DumpRoutingTable()
+	fmt.Fprintf(w, "digraph routing_table {\n")
+	fmt.Fprintf(w, "\trankdir=LR;\n")
+	fmt.Fprintf(w, "\tnode [shape=box, fontname=\"monospace\"];\n")
+	fmt.Fprintf(w, "\t\"%s\" [label=\"%s\\n%s\", style=bold];\n",
+		dump.NodeID, dump.NodeID[:8], net.JoinHostPort(dump.Host, fmt.Sprint(dump.Port)))
+
+	bucket := -1
+	for _, c := range dump.Contacts {
+		if c.Bucket != bucket {
+			if bucket >= 0 {
+				fmt.Fprintf(w, "\t}\n")
+			}
+			bucket = c.Bucket
+			fmt.Fprintf(w, "\tsubgraph cluster_%d {\n\t\tlabel=\"bucket %d\";\n", bucket, bucket)
+		}
+		label := c.ID[:8] + "\\n" + net.JoinHostPort(c.Host, fmt.Sprint(c.Port))
+		color := "black"
+		if c.Failures > 0 {
+			label += fmt.Sprintf("\\n%d failures", c.Failures)
+			color = "red"
+		}
+		fmt.Fprintf(w, "\t\t\"%s\" [label=\"%s\", color=%s];\n", c.ID, label, color)
+	}
+	if bucket >= 0 {
+		fmt.Fprintf(w, "\t}\n")
+	}
+
+	for _, c := range dump.Contacts {
+		fmt.Fprintf(w, "\t\"%s\" -> \"%s\";\n", dump.NodeID, c.ID)
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
+
+// ImportRoutingTable : Add the contacts of a JSON dump to the routing table,
+// returning how many went in. With ping, only contacts which answer a ping
+// from their address with their ID are added.
+func (k *Kademlia) ImportRoutingTable(r io.Reader, ping bool) (added int, err error) {
+	var dump RoutingTableDump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return 0, err
+	}
+
+	// The node the dump came from is a contact too
+	entries := append([]ContactDump{{ID: dump.NodeID, Host: dump.Host, AltHosts: dump.AltHosts, Port: dump.Port}}, dump.Contacts...)
+	for _, e := range entries {
+		id, err := IDFromString(e.ID)
+		if err != nil || id.Equals(k.NodeID) {
+			continue
+		}
+		host := net.ParseIP(e.Host)
+		if host == nil {
+			continue
+		}
+		var alt []net.IP
+		for _, a := range e.AltHosts {
+			if ip := net.ParseIP(a); ip != nil {
+				alt = append(alt, ip)
+			}
+		}
+		contact := Contact{id, host, e.Port, alt}
+
+		if ping {
+			// The pong only adds the contact if its bucket has room for it
+			if pong, err := k.DoPingContact(&contact); err == nil && pong.NodeID.Equals(id) {
+				if _, err := k.RT.LookUp(id); err == nil {
+					added++
+				}
+			}
+			continue
+		}
+		if k.RT.Update(contact) == nil {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// ipStrings : ips as strings, nil if there are none
+func ipStrings(ips []net.IP) []string {
+	var out []string
+	for _, ip := range ips {
+		out = append(out, ip.String())
+	}
+	return out
+}
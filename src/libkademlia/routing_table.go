@@ -4,20 +4,27 @@ These functions except Init and Finalize are thread safe
 
 package libkademlia
 
+import (
+	"time"
+)
+
 // Init : Not thread safe, should be called only once. Must be called before all other functions can work
 func (tab *RoutingTable) Init(Self *Kademlia) error {
 	for i := 0; i < b+1; i++ {
-		tab.Buckets[i].Init()
+		tab.Buckets[i].Init(Self.cfg().BucketSize)
 	}
 	tab.EventChan = make(chan RountingTableEvent)
+	tab.done = make(chan bool)
 	tab.Self = Self
+	tab.Seen = make(map[ID]time.Time)
+	tab.Failures = make(map[ID]int)
 	go tab.Dispatcher()
 	return nil
 }
 
 // Finalize : Not thread safe, should be called only once. Must be called before program exit. All functions can't be called after Finalize
 func (tab *RoutingTable) Finalize() error {
-	E := RountingTableEventArg{nil, nil, nil}
+	E := RountingTableEventArg{nil, nil, nil, nil, nil}
 	tab.Delegate(ROUTING_TABLE_EVENT_FINALIZE, E)
 	return nil
 }
@@ -32,14 +39,14 @@ func (tab *RoutingTable) UpdateN(C []Contact) (err []error) {
 
 // Update :
 func (tab *RoutingTable) Update(C Contact) error {
-	E := RountingTableEventArg{nil, &C, nil}
+	E := RountingTableEventArg{nil, &C, nil, nil, nil}
 	return tab.Delegate(ROUTING_TABLE_EVENT_UPDATE, E)
 }
 
 // FindNearestNode : FIND_NODE
 func (tab *RoutingTable) FindNearestNode(id ID) (C []Contact, num int, err error) {
 	var T *[]Contact
-	E := RountingTableEventArg{&id, nil, &T}
+	E := RountingTableEventArg{&id, nil, &T, nil, nil}
 	ret := tab.Delegate(ROUTING_TABLE_EVENT_FIND_NEAREST_NODE, E)
 	C = **(E.CS)
 	return C, len(C), ret
@@ -48,7 +55,7 @@ func (tab *RoutingTable) FindNearestNode(id ID) (C []Contact, num int, err error
 // FindAlphaNearestNode : FIND_NODE
 func (tab *RoutingTable) FindAlphaNearestNode(id ID) (C []Contact, num int, err error) {
 	var T *[]Contact
-	E := RountingTableEventArg{&id, nil, &T}
+	E := RountingTableEventArg{&id, nil, &T, nil, nil}
 	ret := tab.Delegate(ROUTING_TABLE_EVENT_FIND_ALPHA_NEAREST_NODE, E)
 	C = **(E.CS)
 	return C, len(C), ret
@@ -57,32 +64,45 @@ func (tab *RoutingTable) FindAlphaNearestNode(id ID) (C []Contact, num int, err
 // LookUp : ID to Contact
 func (tab *RoutingTable) LookUp(id ID) (C Contact, err error) {
 	var T Contact
-	E := RountingTableEventArg{&id, &T, nil}
+	E := RountingTableEventArg{&id, &T, nil, nil, nil}
 	ret := tab.Delegate(ROUTING_TABLE_EVENT_LOOK_UP, E)
 	C = T
 	return C, ret
 }
 
+// Dump : Every contact with when it was last seen and its failure count
+func (tab *RoutingTable) Dump() []ContactInfo {
+	var info []ContactInfo
+	E := RountingTableEventArg{nil, nil, nil, &info, nil}
+	tab.Delegate(ROUTING_TABLE_EVENT_DUMP, E)
+	return info
+}
+
+// Failed : Count a failed RPC to a contact, if it's in the table
+func (tab *RoutingTable) Failed(id ID) error {
+	E := RountingTableEventArg{&id, nil, nil, nil, nil}
+	return tab.Delegate(ROUTING_TABLE_EVENT_FAILED, E)
+}
+
 // Size :
 func (tab *RoutingTable) Size() int {
 	ret := 0
-	for i := 0; i < b+1; i++ {
-		ret += tab.Buckets[i].size
+	for _, size := range tab.Info() {
+		ret += size
 	}
 	return ret
 }
 
 // Info : Return size of each bucket
 func (tab *RoutingTable) Info() []int {
-	info := make([]int, b+1)
-	for i, bucket := range tab.Buckets {
-		info[i] = bucket.size
-	}
+	var info []int
+	E := RountingTableEventArg{nil, nil, nil, nil, &info}
+	tab.Delegate(ROUTING_TABLE_EVENT_INFO, E)
 	return info
 }
 
 // UpdateInternal :
 func (tab *RoutingTable) UpdateInternal(C Contact) error {
-	E := RountingTableEventArg{nil, &C, nil}
+	E := RountingTableEventArg{nil, &C, nil, nil, nil}
 	return tab.UpdateCore(E)
 }
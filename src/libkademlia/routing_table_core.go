@@ -6,7 +6,7 @@ package libkademlia
 
 import (
 	"errors"
-	"fmt"
+	"time"
 )
 
 const (
@@ -15,13 +15,27 @@ const (
 	ROUTING_TABLE_EVENT_LOOK_UP                 = 3
 	ROUTING_TABLE_EVENT_FINALIZE                = 4
 	ROUTING_TABLE_EVENT_FIND_ALPHA_NEAREST_NODE = 5
+	ROUTING_TABLE_EVENT_DUMP                    = 6
+	ROUTING_TABLE_EVENT_FAILED                  = 7
+	ROUTING_TABLE_EVENT_INFO                    = 8
 )
 
 // RoutingTable : one more bucket for exactly the same, not used
 type RoutingTable struct {
 	Buckets   [b + 1]Bucket
 	EventChan chan RountingTableEvent
+	done      chan bool // closed when the dispatcher stops
 	Self      *Kademlia
+	Seen      map[ID]time.Time // when each contact was last added or heard from
+	Failures  map[ID]int       // failed RPCs to each contact since it was last seen
+}
+
+// ContactInfo : A routing table entry and what we know about it
+type ContactInfo struct {
+	Contact
+	Bucket   int
+	LastSeen time.Time
+	Failures int
 }
 
 // RountingTableEvent :
@@ -33,13 +47,16 @@ type RountingTableEvent struct {
 
 // RountingTableEventArg :
 type RountingTableEventArg struct {
-	ID *ID
-	C  *Contact
-	CS **[]Contact
+	ID    *ID
+	C     *Contact
+	CS    **[]Contact
+	Info  *[]ContactInfo
+	Sizes *[]int
 }
 
 // Dispatcher :
 func (tab *RoutingTable) Dispatcher() {
+	defer close(tab.done)
 	var Event RountingTableEvent
 	var Ret error
 	running := true
@@ -60,11 +77,20 @@ func (tab *RoutingTable) Dispatcher() {
 			case ROUTING_TABLE_EVENT_LOOK_UP:
 				Ret = tab.LookUpCore(Event.Arg)
 				break
+			case ROUTING_TABLE_EVENT_DUMP:
+				Ret = tab.DumpCore(Event.Arg)
+				break
+			case ROUTING_TABLE_EVENT_FAILED:
+				Ret = tab.FailedCore(Event.Arg)
+				break
+			case ROUTING_TABLE_EVENT_INFO:
+				Ret = tab.InfoCore(Event.Arg)
+				break
 			case ROUTING_TABLE_EVENT_FINALIZE:
 				running = false
 				break
 			default:
-				fmt.Printf("Err: unknown command\n")
+				tab.Self.Logger().Error("Unknown event", "op", "routing_table", "event", Event.EventID)
 			}
 			// return value
 			Event.Ret <- Ret
@@ -78,7 +104,11 @@ func (tab *RoutingTable) Dispatcher() {
 func (tab *RoutingTable) Delegate(EventID int, Arg RountingTableEventArg) error {
 	retchan := make(chan error)
 	E := RountingTableEvent{EventID, Arg, retchan}
-	tab.EventChan <- E
+	select {
+	case tab.EventChan <- E:
+	case <-tab.done:
+		return errors.New("Table finalized")
+	}
 	err, ok := <-E.Ret
 	if ok {
 		return err
@@ -93,19 +123,30 @@ func (tab *RoutingTable) UpdateCore(Arg RountingTableEventArg) error {
 	if dist < b {
 		err := tab.Buckets[dist].MoveFront(C)
 		if err != nil { // Not in list
-			if tab.Buckets[dist].size < k { // Not full
+			if tab.Buckets[dist].size < tab.Self.cfg().BucketSize { // Not full
 				tab.Buckets[dist].PushBack(C)
+				tab.touch(dist, C.NodeID)
+				tab.Self.metrics.Add("kademlia_routing_table_added_total", "", 1)
+				tab.Self.emit(Event{Kind: EventContactAdded, Contact: C})
 				return nil
 			}
+			// The pong moves a live head to the back, and C is dropped
 			H, _ := tab.Buckets[dist].Top()
 			_, err = tab.Self.DoInternalPing(H.Host, H.Port)
-			if err != nil { // Can ping head
+			if err == nil {
 				return errors.New("Bucket full")
 			}
 			tab.Buckets[dist].Pop()
 			tab.Buckets[dist].PushBack(C)
+			tab.touch(dist, H.NodeID)
+			tab.Self.metrics.Add("kademlia_routing_table_evicted_total", "", 1)
+			tab.Self.emit(Event{Kind: EventContactEvicted, Contact: H})
+			tab.touch(dist, C.NodeID)
+			tab.Self.metrics.Add("kademlia_routing_table_added_total", "", 1)
+			tab.Self.emit(Event{Kind: EventContactAdded, Contact: C})
 			return nil
 		}
+		tab.touch(dist, C.NodeID)
 	} else {
 		return errors.New("Can not add self to table")
 	}
@@ -117,6 +158,7 @@ func (tab *RoutingTable) FindNearestNodeCore(Arg RountingTableEventArg) error {
 	var C []Contact
 	id := *Arg.ID
 	dist := (tab.Self.NodeID.Xor(id)).PrefixLenEx()
+	k := tab.Self.cfg().BucketSize
 	for j := dist; j < b; j++ {
 		if len(C) < k {
 			for i := 0; i < tab.Buckets[j].size && len(C) < k; i++ {
@@ -146,6 +188,7 @@ func (tab *RoutingTable) FindAlphaNearestNodeCore(Arg RountingTableEventArg) err
 	var C []Contact
 	id := *Arg.ID
 	dist := (tab.Self.NodeID.Xor(id)).PrefixLenEx()
+	alpha := tab.Self.cfg().Alpha
 	for j := dist; j < alpha; j++ {
 		if len(C) < alpha {
 			for i := 0; i < tab.Buckets[j].size && len(C) < alpha; i++ {
@@ -170,6 +213,56 @@ func (tab *RoutingTable) FindAlphaNearestNodeCore(Arg RountingTableEventArg) err
 	return nil
 }
 
+// touch : Mark a contact seen if it's in the bucket, forget it otherwise.
+// Returns whether it's in the bucket.
+func (tab *RoutingTable) touch(dist int, id ID) bool {
+	if _, err := tab.Buckets[dist].Find(id); err != nil {
+		delete(tab.Seen, id)
+		delete(tab.Failures, id)
+		return false
+	}
+	tab.Seen[id] = time.Now()
+	delete(tab.Failures, id)
+	return true
+}
+
+// DumpCore : Every contact, by bucket and least recently seen first
+func (tab *RoutingTable) DumpCore(Arg RountingTableEventArg) error {
+	var info []ContactInfo
+	for i := 0; i < b; i++ {
+		for j := 0; j < tab.Buckets[i].size; j++ {
+			C, _ := tab.Buckets[i].Get(j)
+			info = append(info, ContactInfo{C, i, tab.Seen[C.NodeID], tab.Failures[C.NodeID]})
+		}
+	}
+	*Arg.Info = info
+	return nil
+}
+
+// InfoCore : Size of each bucket
+func (tab *RoutingTable) InfoCore(Arg RountingTableEventArg) error {
+	sizes := make([]int, b+1)
+	for i := range tab.Buckets {
+		sizes[i] = tab.Buckets[i].size
+	}
+	*Arg.Sizes = sizes
+	return nil
+}
+
+// FailedCore : Count a failed RPC to a contact in the table
+func (tab *RoutingTable) FailedCore(Arg RountingTableEventArg) error {
+	id := *(Arg.ID)
+	dist := (tab.Self.NodeID.Xor(id)).PrefixLenEx()
+	if dist >= b {
+		return errors.New("Can not fail self")
+	}
+	if _, err := tab.Buckets[dist].Find(id); err != nil {
+		return err
+	}
+	tab.Failures[id]++
+	return nil
+}
+
 // LookUpCore : ID to Contact
 func (tab *RoutingTable) LookUpCore(Arg RountingTableEventArg) error {
 	id := *(Arg.ID)
@@ -5,19 +5,31 @@ package libkademlia
 // other groups' code.
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"time"
 )
 
 type KademliaRPC struct {
 	kademlia *Kademlia
 }
 
+// received : Count and log an incoming RPC
+func (k *Kademlia) received(op string, sender Contact, msgID ID) {
+	k.metrics.rpcReceived(op)
+	k.emit(Event{Kind: EventRPCReceived, Contact: sender, Method: op, MsgID: msgID})
+	k.Logger().Debug("RPC received", "op", op, "peer", sender.NodeID.AsString(),
+		"addr", net.JoinHostPort(sender.Host.String(), fmt.Sprint(sender.Port)), "msg_id", msgID.AsString())
+}
+
 // Host identification.
 type Contact struct {
 	NodeID ID
 	Host   net.IP
 	Port   uint16
+	// Other addresses of a dual-stack node, on the same port
+	AltHosts []net.IP
 }
 
 // RPCError return error type
@@ -43,6 +55,7 @@ type PongMessage struct {
 }
 
 func (k *KademliaRPC) Ping(ping PingMessage, pong *PongMessage) error {
+	k.kademlia.received("Ping", ping.Sender, ping.MsgID)
 	pong.MsgID = CopyID(ping.MsgID)
 	// Specify the sende
 	pong.Sender = k.kademlia.SelfContact
@@ -67,8 +80,27 @@ type StoreResult struct {
 }
 
 func (k *KademliaRPC) Store(req StoreRequest, res *StoreResult) error {
+	k.kademlia.received("Store", req.Sender, req.MsgID)
 	res.MsgID = CopyID(req.MsgID)
-	res.Err = k.kademlia.HT.Add(req.Key, req.Value)
+	res.Err = k.kademlia.HT.AddEx(req.Key, req.Value, k.kademlia.cfg().storeTTLSeconds())
+	// Update contact
+	k.kademlia.RT.Update(req.Sender)
+	return nil
+}
+
+// StoreExRequest : STORE with an expiration time in seconds
+type StoreExRequest struct {
+	Sender Contact
+	MsgID  ID
+	Key    ID
+	Value  []byte
+	Expire int64
+}
+
+func (k *KademliaRPC) StoreEx(req StoreExRequest, res *StoreResult) error {
+	k.kademlia.received("StoreEx", req.Sender, req.MsgID)
+	res.MsgID = CopyID(req.MsgID)
+	res.Err = k.kademlia.HT.AddEx(req.Key, req.Value, req.Expire)
 	// Update contact
 	k.kademlia.RT.Update(req.Sender)
 	return nil
@@ -90,6 +122,7 @@ type FindNodeResult struct {
 }
 
 func (k *KademliaRPC) FindNode(req FindNodeRequest, res *FindNodeResult) error {
+	k.kademlia.received("FindNode", req.Sender, req.MsgID)
 	//var resultCount int
 	// Fill up result
 	res.MsgID = CopyID(req.MsgID)
@@ -113,15 +146,25 @@ type FindValueRequest struct {
 type FindValueResult struct {
 	MsgID ID
 	Value []byte
+	TTL   time.Duration // how long the value has left, 0 if it never expires
 	Nodes []Contact
 	Err   RPCError
 }
 
 func (k *KademliaRPC) FindValue(req FindValueRequest, res *FindValueResult) error {
+	k.kademlia.received("FindValue", req.Sender, req.MsgID)
 	// Fill up result
 	res.MsgID = CopyID(req.MsgID)
 	var err error
-	res.Value, res.Nodes, err = k.kademlia.HT.FindValueAndContact(req.Key)
+	var expire time.Time
+	res.Value, res.Nodes, expire, err = k.kademlia.HT.FindValueAndContact(req.Key)
+	if !expire.IsZero() {
+		// At least a nanosecond, 0 means it never expires
+		res.TTL = time.Until(expire)
+		if res.TTL <= 0 {
+			res.TTL = 1
+		}
+	}
 
 	//	res.Nodes, _, res.Err = k.kademlia.RT.FindNearestNode(req.Key)
 	//	res.Value, res.Err = k.kademlia.HT.Find(req.Key)
@@ -151,6 +194,7 @@ type GetVDOResult struct {
 }
 
 func (k *KademliaRPC) GetVDO(req GetVDORequest, res *GetVDOResult) error {
+	k.kademlia.received("GetVDO", req.Sender, req.MsgID)
 	// TODO: Implement.
 	res.MsgID = CopyID(req.MsgID)
 	var err error
@@ -162,3 +206,36 @@ func (k *KademliaRPC) GetVDO(req GetVDORequest, res *GetVDOResult) error {
 	}
 	return nil
 }
+
+type StoreVDORequest struct {
+	Sender Contact
+	VdoID  ID
+	VDO    VanashingDataObject
+	MsgID  ID
+	Expire int64
+}
+
+type StoreVDOResult struct {
+	MsgID ID
+	Err   RPCError
+}
+
+func (k *KademliaRPC) StoreVDO(req StoreVDORequest, res *StoreVDOResult) error {
+	k.kademlia.received("StoreVDO", req.Sender, req.MsgID)
+	res.MsgID = CopyID(req.MsgID)
+	// Refreshing a replica is fine, replacing it with another VDO isn't
+	var err error
+	if !req.VDO.valid() {
+		err = errors.New("Invalid VDO")
+	} else {
+		err = k.kademlia.DT.AddReplica(req.VdoID, req.VDO, req.Expire)
+	}
+	if err != nil {
+		res.Err = RPCError{err.Error()}
+	} else {
+		res.Err = RPCError{}
+	}
+	// Update contact
+	k.kademlia.RT.Update(req.Sender)
+	return nil
+}
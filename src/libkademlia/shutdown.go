@@ -0,0 +1,126 @@
+package libkademlia
+
+// Stopping a node. RPC connections are hijacked from the HTTP server, which
+// then forgets about them, so the node keeps track of them itself to let the
+// RPCs in flight finish before the tables go away.
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+)
+
+// rpcConns : The RPC connections being served
+type rpcConns struct {
+	mutex   sync.Mutex
+	conns   map[net.Conn]bool
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// serveRPC : s.ServeHTTP, refusing connections once the node is closing
+func (k *Kademlia) serveRPC(s *rpc.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		k.rpcConns.mutex.Lock()
+		if k.rpcConns.closing {
+			k.rpcConns.mutex.Unlock()
+			http.Error(w, "Node is closing", http.StatusServiceUnavailable)
+			return
+		}
+		k.rpcConns.wg.Add(1)
+		k.rpcConns.mutex.Unlock()
+		defer k.rpcConns.wg.Done()
+
+		// ServeHTTP returns once the client closes the connection
+		hw := &hijackWriter{ResponseWriter: w, c: &k.rpcConns}
+		s.ServeHTTP(hw, req)
+		if hw.conn != nil {
+			k.rpcConns.mutex.Lock()
+			delete(k.rpcConns.conns, hw.conn)
+			k.rpcConns.mutex.Unlock()
+		}
+	})
+}
+
+// hijackWriter : Records the connection the RPC server hijacks
+type hijackWriter struct {
+	http.ResponseWriter
+	c    *rpcConns
+	conn net.Conn
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		w.conn = conn
+		w.c.mutex.Lock()
+		w.c.conns[conn] = true
+		w.c.mutex.Unlock()
+	}
+	return conn, rw, err
+}
+
+// drain : Refuse new RPC connections and wait for the open ones to be closed
+// by their clients, closing them once ctx is done
+func (c *rpcConns) drain(ctx context.Context) error {
+	c.mutex.Lock()
+	c.closing = true
+	c.mutex.Unlock()
+
+	drained := make(chan bool)
+	go func() {
+		c.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+	c.mutex.Lock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mutex.Unlock()
+	<-drained
+	return ctx.Err()
+}
+
+// Close : Stop the node. It stops accepting connections and waits for the RPCs
+// in flight until ctx is done, when the remaining connections are cut off.
+// Then bootstrap retries, sweepers and the tables are stopped, values and VDOs
+// saved and subscriptions closed. The port is free once Close returns. Calling
+// Close again does nothing.
+func (k *Kademlia) Close(ctx context.Context) error {
+	var err error
+	k.closeOnce.Do(func() {
+		close(k.quit)
+		err = k.server.Shutdown(ctx)
+		if drainErr := k.rpcConns.drain(ctx); err == nil {
+			err = drainErr
+		}
+
+		k.RT.Finalize()
+		if saveErr := k.HT.Finalize(); saveErr != nil {
+			k.logger.Error("Saving values failed", "op", "close", "err", saveErr)
+			if err == nil {
+				err = saveErr
+			}
+		}
+		if saveErr := k.DT.Finalize(); saveErr != nil {
+			k.logger.Error("Saving VDOs failed", "op", "close", "err", saveErr)
+			if err == nil {
+				err = saveErr
+			}
+		}
+		k.closeEvents()
+		k.logger.Info("Closed")
+		if k.logFile != nil {
+			k.logFile.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package libkademlia
+
+// Saving the hash table and data table to Config.DataDir, so a restarted node
+// keeps serving what it stored. The files are plaintext and hold Vanish key
+// shares, so they are rewritten whenever a sweep drops expired entries; a share
+// is gone from the data dir once it expires, not only from memory.
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	valuesFile = "values.gob"
+	vdosFile   = "vdos.gob"
+)
+
+// dataTableState : What DataTable saves
+type dataTableState struct {
+	Table  map[ID]VanashingDataObject
+	Expire map[ID]time.Time
+}
+
+// saveState : Write v to name in the data dir, replacing the old file only once
+// the new one is complete
+func (k *Kademlia) saveState(name string, v interface{}) error {
+	dir := k.cfg().DataDir
+	if k == nil || dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadState : Read name in the data dir into v, a missing file is no error
+func (k *Kademlia) loadState(name string, v interface{}) error {
+	dir := k.cfg().DataDir
+	if k == nil || dir == "" {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewDecoder(f).Decode(v)
+}
+
+// saveCore : Rewrite the values file after entries expired, only from the
+// dispatcher
+func (tab *HashTable) saveCore() {
+	tab.dirty = false
+	if err := tab.Self.saveState(valuesFile, tab.Table); err != nil {
+		tab.Self.Logger().Error("Saving values failed", "op", "sweep", "err", err)
+	}
+}
+
+// saveLocked : Rewrite the VDOs file after objects expired, tab.Mutex must be
+// held
+func (tab *DataTable) saveLocked() {
+	tab.dirty = false
+	if err := tab.Parent.saveState(vdosFile, dataTableState{tab.Table, tab.Expire}); err != nil {
+		tab.Parent.Logger().Error("Saving VDOs failed", "op", "sweep", "err", err)
+	}
+}
@@ -0,0 +1,169 @@
+package libkademlia
+
+// Round by round record of an iterative lookup, for finding out why a lookup
+// came back with poor results. Tracing is off unless a lookup is started
+// through one of the ...Trace functions; a nil *LookupTrace records nothing.
+//
+// Distances are given as the number of leading bits a contact shares with the
+// target, so higher is closer.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"strconv"
+	"time"
+)
+
+// LookupTrace : What an iterative lookup did
+type LookupTrace struct {
+	Kind       string       `json:"kind"` // "node" or "value"
+	Target     string       `json:"target"`
+	Start      time.Time    `json:"start"`
+	DurationMs float64      `json:"duration_ms"`
+	Rounds     []TraceRound `json:"rounds"`
+	Found      bool         `json:"found,omitempty"` // value lookups only
+	Contacts   int          `json:"contacts"`        // node lookups only
+	Err        string       `json:"error,omitempty"`
+}
+
+// TraceRound : One round of parallel RPCs and the shortlist after it
+type TraceRound struct {
+	Round      int          `json:"round"`
+	Step       string       `json:"step"` // "alpha", or "remaining" for the final pass over uncontacted nodes
+	Queried    []TraceQuery `json:"queried"`
+	BestBefore int          `json:"best_prefix_before"`
+	BestAfter  int          `json:"best_prefix_after"`
+	Shortlist  int          `json:"shortlist"`
+	Active     int          `json:"active"`
+	DurationMs float64      `json:"duration_ms"`
+	start      time.Time
+}
+
+// TraceQuery : One contact asked in a round
+type TraceQuery struct {
+	ID       string `json:"id"`
+	Addr     string `json:"addr"`
+	Prefix   int    `json:"prefix"`
+	Result   string `json:"result"` // ok, value, timeout or error
+	Returned int    `json:"returned"`
+	New      int    `json:"new"` // returned contacts that weren't in the shortlist
+	Err      string `json:"error,omitempty"`
+}
+
+func newLookupTrace(kind string, target ID) *LookupTrace {
+	return &LookupTrace{Kind: kind, Target: target.AsString(), Start: time.Now(), Rounds: []TraceRound{}}
+}
+
+// startRound : Begin a round, nil if not tracing
+func (t *LookupTrace) startRound(step string, l *ShortList) *TraceRound {
+	if t == nil {
+		return nil
+	}
+	return &TraceRound{
+		Round:      len(t.Rounds) + 1,
+		Step:       step,
+		Queried:    []TraceQuery{},
+		BestBefore: bestPrefix(l),
+		start:      time.Now(),
+	}
+}
+
+// query : Record the answer of one contact
+func (r *TraceRound) query(c Contact, target ID, returned, added int, valueFound bool, err error) {
+	if r == nil {
+		return
+	}
+	q := TraceQuery{
+		ID:       c.NodeID.AsString(),
+		Addr:     net.JoinHostPort(c.Host.String(), strconv.Itoa(int(c.Port))),
+		Prefix:   c.NodeID.Xor(target).PrefixLenEx(),
+		Result:   "ok",
+		Returned: returned,
+		New:      added,
+	}
+	var netErr net.Error
+	switch {
+	case err != nil && errors.As(err, &netErr) && netErr.Timeout():
+		q.Result, q.Err = "timeout", err.Error()
+	case err != nil:
+		q.Result, q.Err = "error", err.Error()
+	case valueFound:
+		q.Result = "value"
+	}
+	r.Queried = append(r.Queried, q)
+}
+
+// endRound : Record the shortlist after the round
+func (t *LookupTrace) endRound(r *TraceRound, l *ShortList) {
+	if t == nil {
+		return
+	}
+	r.BestAfter = bestPrefix(l)
+	r.Shortlist = l.Size()
+	r.Active = l.ActiveSize()
+	r.DurationMs = float64(time.Since(r.start)) / float64(time.Millisecond)
+	t.Rounds = append(t.Rounds, *r)
+}
+
+func (t *LookupTrace) finish(found bool, contacts int, err error) {
+	if t == nil {
+		return
+	}
+	t.DurationMs = float64(time.Since(t.Start)) / float64(time.Millisecond)
+	t.Found = found
+	t.Contacts = contacts
+	if err != nil {
+		t.Err = err.Error()
+	}
+}
+
+// bestPrefix : The longest prefix any shortlist contact shares with the
+// target, -1 if the list is empty
+func bestPrefix(l *ShortList) int {
+	best := -1
+	for _, E := range l.Entries {
+		if E.Dist > best {
+			best = E.Dist
+		}
+	}
+	return best
+}
+
+// failedCall : A finished call carrying err, for contacts that couldn't be
+// dialed, so waiting on it reports why
+func failedCall(err error) *rpc.Call {
+	call := &rpc.Call{Error: err, Done: make(chan *rpc.Call, 1)}
+	call.Done <- call
+	return call
+}
+
+// WriteReport : Write the trace as a hop by hop report
+func (t *LookupTrace) WriteReport(w io.Writer) error {
+	fmt.Fprintf(w, "find %s %s: %d rounds in %.1fms\n", t.Kind, t.Target, len(t.Rounds), t.DurationMs)
+	for _, r := range t.Rounds {
+		fmt.Fprintf(w, "round %d (%s): best prefix %d -> %d, shortlist %d, %d active, %.1fms\n",
+			r.Round, r.Step, r.BestBefore, r.BestAfter, r.Shortlist, r.Active, r.DurationMs)
+		for _, q := range r.Queried {
+			fmt.Fprintf(w, "  %s %-21s prefix %3d  %s", q.ID, q.Addr, q.Prefix, q.Result)
+			if q.Err != "" {
+				fmt.Fprintf(w, ": %s", q.Err)
+			} else if q.Result == "ok" {
+				fmt.Fprintf(w, ", %d contacts, %d new", q.Returned, q.New)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	}
+	var err error
+	switch {
+	case t.Err != "":
+		_, err = fmt.Fprintf(w, "failed: %s\n", t.Err)
+	case t.Kind == "value" && t.Found:
+		_, err = fmt.Fprintf(w, "found the value\n")
+	default:
+		_, err = fmt.Fprintf(w, "%d contacts\n", t.Contacts)
+	}
+	return err
+}
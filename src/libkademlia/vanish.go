@@ -4,22 +4,61 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	mathrand "math/rand"
 	"sss"
 	"time"
 )
 
+// VanashingDataObject : ShareHashes[i] is the SHA-256 of the stored share with
+// ID i+1. It commits to the shares, not the key, so holding the VDO doesn't let
+// anyone test a guessed key once the shares are gone.
 type VanashingDataObject struct {
-	AccessKey  int64
-	Ciphertext []byte
-	NumberKeys byte
-	Threshold  byte
+	AccessKey   int64
+	Ciphertext  []byte
+	NumberKeys  byte
+	Threshold   byte
+	ShareHashes [][32]byte
+}
+
+// valid : Whether the VDO is well formed enough to unvanish
+func (vdo *VanashingDataObject) valid() bool {
+	return vdo.NumberKeys != 0 && vdo.Threshold != 0 && vdo.Threshold <= vdo.NumberKeys &&
+		len(vdo.ShareHashes) == int(vdo.NumberKeys) && len(vdo.Ciphertext) >= aes.BlockSize
+}
+
+// digest : Hash of everything in the VDO, to tell replicas apart
+func (vdo *VanashingDataObject) digest() (d [32]byte) {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, vdo.AccessKey)
+	h.Write([]byte{vdo.NumberKeys, vdo.Threshold})
+	for _, s := range vdo.ShareHashes {
+		h.Write(s[:])
+	}
+	h.Write(vdo.Ciphertext)
+	copy(d[:], h.Sum(nil))
+	return d
+}
+
+// ShareRetrievalError : not enough usable shares to rebuild the key
+type ShareRetrievalError struct {
+	Found  int
+	Needed int
+	msg    string
+}
+
+func (e *ShareRetrievalError) Error() string {
+	return fmt.Sprintf("%s: found %d shares, need %d", e.msg, e.Found, e.Needed)
 }
 
 func GenerateRandomCryptoKey() (ret []byte) {
-	for i := 0; i < 32; i++ {
-		ret = append(ret, uint8(mathrand.Intn(256)))
+	ret = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, ret); err != nil {
+		panic(err)
 	}
 	return
 }
@@ -65,11 +104,12 @@ func decrypt(key []byte, ciphertext []byte) (text []byte) {
 		panic("ciphertext is not long enough")
 	}
 	iv := ciphertext[:aes.BlockSize]
-	ciphertext = ciphertext[aes.BlockSize:]
+	// Don't decrypt in place, the VDO may still be sitting in a DataTable
+	text = make([]byte, len(ciphertext)-aes.BlockSize)
 
 	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(ciphertext, ciphertext)
-	return ciphertext
+	stream.XORKeyStream(text, ciphertext[aes.BlockSize:])
+	return text
 }
 
 func (k *Kademlia) VanishData(data []byte, numberKeys byte, threshold byte, timeoutSeconds int) (V VanashingDataObject) {
@@ -78,17 +118,17 @@ func (k *Kademlia) VanishData(data []byte, numberKeys byte, threshold byte, time
 	V.AccessKey = GenerateRandomAccessKey()
 	V.NumberKeys = numberKeys
 	V.Threshold = threshold
-	skey, err := sss.Split(numberKeys, threshold, key)
+	shares, err := sss.SplitShares(numberKeys, threshold, key)
 	if err != nil {
 		V.NumberKeys = 0 // NumberKeys = 0 means error
 		return V
 	}
+	V.ShareHashes = make([][32]byte, numberKeys)
 	addrs := CalculateSharedKeyLocations(V.AccessKey, int64(numberKeys))
-	i := 0
-	for kid, kv := range skey {
-		packed := append([]byte{kid}, kv...)
-		_, err := k.DoIterativeStore(addrs[i], packed)
-		i++
+	for i, s := range shares {
+		packed := s.Marshal()
+		V.ShareHashes[s.ID-1] = sha256.Sum256(packed)
+		_, err := k.DoIterativeStoreEx(addrs[i], packed, int64(timeoutSeconds))
 		if err != nil {
 			V.NumberKeys = 0 // NumberKeys = 0 means error
 			return V
@@ -97,21 +137,55 @@ func (k *Kademlia) VanishData(data []byte, numberKeys byte, threshold byte, time
 	return V
 }
 
-func (k *Kademlia) UnvanishData(vdo VanashingDataObject) (data []byte) {
-	keys := make(map[byte][]byte)
+// UnvanishData : Fetch the key shares in parallel and decrypt the VDO. Shares
+// that don't match the VDO's share hashes are dropped as they arrive.
+func (k *Kademlia) UnvanishData(vdo VanashingDataObject) (data []byte, err error) {
+	needed := int(vdo.Threshold)
+	if vdo.NumberKeys == 0 || needed == 0 || needed > int(vdo.NumberKeys) ||
+		len(vdo.ShareHashes) != int(vdo.NumberKeys) {
+		return nil, errors.New("Invalid VDO")
+	}
+	if len(vdo.Ciphertext) < aes.BlockSize {
+		return nil, errors.New("ciphertext is not long enough")
+	}
 	addrs := CalculateSharedKeyLocations(vdo.AccessKey, int64(vdo.NumberKeys))
+	// Buffered so late lookups can finish after we've returned
+	done := make(chan []byte, len(addrs))
 	for i := 0; i < len(addrs); i++ {
-		packed, err := k.DoIterativeFindValue(addrs[i])
-		if err == nil {
-			kid := packed[0]
-			kv := packed[1:]
-			keys[kid] = kv
+		go func(addr ID) {
+			// Cached copies would outlive the shares' timeout
+			packed, err := k.iterativeFindValue(addr, false, nil)
+			if err != nil {
+				packed = nil
+			}
+			done <- packed
+		}(addrs[i])
+	}
+
+	// Shares that match their hash are correct, so the first needed distinct
+	// ones give the key
+	shares := make(map[byte][]byte)
+	for count := len(addrs); count > 0; count-- {
+		packed := <-done
+		kid, kv, ok := parseShare(packed, vdo)
+		if !ok {
+			continue
+		}
+		shares[kid] = kv
+		if len(shares) == needed {
+			return decrypt(sss.Combine(shares), vdo.Ciphertext), nil
 		}
 	}
-	if len(keys) < int(vdo.Threshold) {
-		return nil
+	return nil, &ShareRetrievalError{len(shares), needed, "Not enough shares"}
+}
+
+// parseShare : Decode a share, rejecting anything that doesn't match the VDO's
+// share hashes
+func parseShare(packed []byte, vdo VanashingDataObject) (kid byte, kv []byte, ok bool) {
+	p, err := sss.ParseShare(packed)
+	if err != nil || int(p.ID) > len(vdo.ShareHashes) ||
+		sha256.Sum256(packed) != vdo.ShareHashes[p.ID-1] {
+		return 0, nil, false
 	}
-	key := sss.Combine(keys)
-	data = decrypt(key, vdo.Ciphertext)
-	return data
+	return p.ID, p.Value, true
 }
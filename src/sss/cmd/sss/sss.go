@@ -0,0 +1,248 @@
+// Command sss splits a secret into share files, any K of which recover it.
+//
+// Usage:
+//
+//	sss split -n N -k K [-prefix PREFIX] [FILE]
+//	sss combine [-o FILE] SHARE...
+//	sss verify SHARE...
+//
+// split reads the secret from FILE, or stdin if FILE is missing or "-", and
+// writes PREFIX.1 to PREFIX.N. combine writes the secret to FILE, or stdout.
+// verify checks that more than K shares are consistent and names the bad ones.
+// Shares are PEM blocks, so they can be printed, mailed or pasted.
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sss"
+	"strconv"
+)
+
+const pemType = "SSS SHARE"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "split":
+		err = split(os.Args[2:])
+	case "combine":
+		err = combine(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "sss: unknown command %q\n", os.Args[1])
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sss %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sss split -n N -k K [-prefix PREFIX] [FILE]")
+	fmt.Fprintln(os.Stderr, "       sss combine [-o FILE] SHARE...")
+	fmt.Fprintln(os.Stderr, "       sss verify SHARE...")
+	os.Exit(2)
+}
+
+func split(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	n := fs.Uint("n", 0, "number of shares to create")
+	k := fs.Uint("k", 0, "number of shares needed to recover the secret")
+	prefix := fs.String("prefix", "", "share file prefix (default FILE.share, or share for stdin)")
+	fs.Parse(args)
+
+	if *n == 0 || *k == 0 {
+		return errors.New("-n and -k are required")
+	}
+	if *n > 255 {
+		return errors.New("-n must be <= 255")
+	}
+	if *k > *n {
+		return errors.New("-k must be <= -n")
+	}
+	if fs.NArg() > 1 {
+		return errors.New("at most one input file")
+	}
+
+	in := fs.Arg(0)
+	var secret []byte
+	var err error
+	if in == "" || in == "-" {
+		secret, err = ioutil.ReadAll(os.Stdin)
+		if *prefix == "" {
+			*prefix = "share"
+		}
+	} else {
+		secret, err = ioutil.ReadFile(in)
+		if *prefix == "" {
+			*prefix = in + ".share"
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return errors.New("secret is empty")
+	}
+
+	shares, err := sss.SplitShares(byte(*n), byte(*k), secret)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range shares {
+		block := &pem.Block{
+			Type: pemType,
+			Headers: map[string]string{
+				"Share":     fmt.Sprintf("%d/%d", s.ID, s.Count),
+				"Threshold": strconv.Itoa(int(s.Threshold)),
+			},
+			Bytes: s.Marshal(),
+		}
+
+		name := fmt.Sprintf("%s.%d", *prefix, s.ID)
+		// the shares are as sensitive as the secret
+		if err := ioutil.WriteFile(name, pem.EncodeToMemory(block), 0600); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "wrote", name)
+	}
+	return nil
+}
+
+func combine(args []string) error {
+	fs := flag.NewFlagSet("combine", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+
+	shares, err := readShares(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	secret, err := sss.CombineShares(shares)
+	if err == sss.ErrNotEnoughShares {
+		return fmt.Errorf("need %d shares, got %d", shares[0].Threshold, len(shares))
+	}
+	if err != nil {
+		return err
+	}
+
+	// with spare shares, check they all agree
+	if len(shares) > int(shares[0].Threshold) {
+		values := make(map[byte][]byte, len(shares))
+		for _, s := range shares {
+			values[s.ID] = s.Value
+		}
+		corrected, bad, err := sss.CombineCorrecting(values, shares[0].Threshold)
+		if err != nil {
+			return fmt.Errorf("shares are inconsistent: %s", err)
+		}
+		if len(bad) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: ignored bad shares %v\n", bad)
+			secret = corrected
+		}
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(secret)
+		return err
+	}
+	return ioutil.WriteFile(*out, secret, 0600)
+}
+
+// verify checks that the shares belong together, and with more than K of them
+// that they all lie on the same polynomial, naming the files which don't
+func verify(args []string) error {
+	shares, err := readShares(args)
+	if err != nil {
+		return err
+	}
+
+	k := int(shares[0].Threshold)
+	fmt.Printf("%d shares of a %d of %d split, secret is %d bytes\n",
+		len(shares), k, shares[0].Count, shares[0].Length)
+	if len(shares) < k {
+		return fmt.Errorf("need %d more shares to recover the secret", k-len(shares))
+	}
+	if len(shares) == k {
+		fmt.Println("enough shares to recover the secret, but none spare to check them against")
+		return nil
+	}
+
+	values := make(map[byte][]byte, len(shares))
+	names := make(map[byte]string, len(shares))
+	for i, s := range shares {
+		values[s.ID] = s.Value
+		names[s.ID] = args[i]
+	}
+	_, bad, err := sss.CombineCorrecting(values, shares[0].Threshold)
+	if err != nil {
+		return fmt.Errorf("shares are inconsistent: %s", err)
+	}
+	for _, id := range bad {
+		fmt.Printf("bad share: %s\n", names[id])
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%d of %d shares are bad", len(bad), len(shares))
+	}
+	fmt.Println("all shares are consistent and enough to recover the secret")
+	return nil
+}
+
+// readShares parses share files and checks they belong to the same split
+func readShares(names []string) ([]sss.Share, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no share files given")
+	}
+
+	var shares []sss.Share
+	seen := make(map[byte]string)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != pemType {
+			return nil, fmt.Errorf("%s: not a share file", name)
+		}
+
+		s, err := sss.ParseShare(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		if len(shares) > 0 {
+			first := shares[0]
+			if s.SecretID != first.SecretID {
+				return nil, fmt.Errorf("%s: from a different split than %s", name, names[0])
+			}
+			if s.Threshold != first.Threshold || s.Count != first.Count || s.Length != first.Length {
+				return nil, fmt.Errorf("%s: parameters differ from %s", name, names[0])
+			}
+		}
+
+		if other, ok := seen[s.ID]; ok {
+			return nil, fmt.Errorf("%s: same share as %s", name, other)
+		}
+		seen[s.ID] = name
+		shares = append(shares, *s)
+	}
+	return shares, nil
+}
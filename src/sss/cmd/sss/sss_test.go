@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sss"
+	"testing"
+)
+
+// splitFile writes secret to a temporary file and splits it into n shares,
+// returning their file names
+func splitFile(t *testing.T, n, k int, secret []byte) []string {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(in, secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := split([]string{"-n", fmt.Sprint(n), "-k", fmt.Sprint(k), in}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s.share.%d", in, i+1)
+	}
+	return names
+}
+
+// corrupt flips a byte of a share's value, keeping the file well formed
+func corrupt(t *testing.T, name string) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(data)
+	s, err := sss.ParseShare(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Value[0] ^= 0xff
+	block.Bytes = s.Marshal()
+	if err := ioutil.WriteFile(name, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func combineFiles(t *testing.T, names []string) ([]byte, error) {
+	out := filepath.Join(t.TempDir(), "out")
+	if err := combine(append([]string{"-o", out}, names...)); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("well hello there!")
+	names := splitFile(t, 5, 3, secret)
+
+	actual, err := combineFiles(t, []string{names[4], names[0], names[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	if _, err := combineFiles(t, names[:2]); err == nil {
+		t.Error("Combining 2 of 3 shares should fail")
+	}
+}
+
+func TestCombineCorrectsBadShare(t *testing.T) {
+	secret := []byte("well hello there!")
+	names := splitFile(t, 5, 3, secret)
+	corrupt(t, names[1])
+
+	actual, err := combineFiles(t, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	names := splitFile(t, 5, 3, []byte("well hello there!"))
+
+	if err := verify(names); err != nil {
+		t.Errorf("Verifying good shares failed: %v", err)
+	}
+
+	if err := verify(names[:3]); err != nil {
+		t.Errorf("Verifying exactly K shares failed: %v", err)
+	}
+
+	if err := verify(names[:2]); err == nil {
+		t.Error("Verifying fewer than K shares should fail")
+	}
+
+	corrupt(t, names[3])
+	if err := verify(names); err == nil {
+		t.Error("Verifying a corrupted share set should fail")
+	}
+}
+
+func TestVerifyMixedSplits(t *testing.T) {
+	a := splitFile(t, 5, 3, []byte("well hello there!"))
+	b := splitFile(t, 5, 3, []byte("well hello there!"))
+
+	if err := verify([]string{a[0], a[1], b[2]}); err == nil {
+		t.Error("Verifying shares of different splits should fail")
+	}
+}
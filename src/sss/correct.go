@@ -0,0 +1,187 @@
+package sss
+
+// Error correction with the Berlekamp-Welch algorithm.
+//
+// Shamir shares are a Reed-Solomon codeword: the share values are a polynomial
+// P of degree K-1 evaluated at the share IDs. Given M > K shares of which at
+// most E = (M-K)/2 are wrong, there is a monic error locator E(x) of degree E
+// which is zero at every wrong share, and a Q(x) = P(x)E(x) of degree E+K-1.
+// For every share (x, y), Q(x) = y * E(x) holds even when y is wrong, which is
+// a linear system in the coefficients of Q and E. Solving it and dividing Q by
+// E recovers P, and the shares which don't lie on P are the bad ones.
+//
+// Unlike Combine, decoding branches on the share values and is not constant
+// time.
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrTooManyErrors is returned when there are too many bad shares to correct.
+var ErrTooManyErrors = errors.New("too many bad shares to correct")
+
+// CombineCorrecting combines shares made with threshold K into the original
+// secret, correcting up to (len(shares)-K)/2 bad shares. It returns the IDs of
+// the shares which were wrong, in ascending order.
+func CombineCorrecting(shares map[byte][]byte, k byte) ([]byte, []byte, error) {
+	if k <= 1 {
+		return nil, nil, ErrInvalidThreshold
+	}
+
+	if len(shares) < int(k) {
+		return nil, nil, ErrNotEnoughShares
+	}
+
+	xs := make([]byte, 0, len(shares))
+	length := -1
+	for x, v := range shares {
+		if x == 0 {
+			return nil, nil, ErrInvalidShareID
+		}
+		if length >= 0 && len(v) != length {
+			return nil, nil, ErrShareMismatch
+		}
+		length = len(v)
+		xs = append(xs, x)
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i] < xs[j] })
+
+	e := (len(xs) - int(k)) / 2
+	secret := make([]byte, length)
+	wrong := make(map[byte]bool)
+	points := make([]pair, len(xs))
+	for i := range secret {
+		for p, x := range xs {
+			points[p] = pair{x: x, y: shares[x][i]}
+		}
+
+		poly, err := decode(points, int(k), e)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		secret[i] = poly[0]
+		for _, pt := range points {
+			if eval(poly, pt.x) != pt.y {
+				wrong[pt.x] = true
+			}
+		}
+	}
+
+	if len(wrong) > e {
+		return nil, nil, ErrTooManyErrors
+	}
+
+	bad := make([]byte, 0, len(wrong))
+	for _, x := range xs {
+		if wrong[x] {
+			bad = append(bad, x)
+		}
+	}
+	return secret, bad, nil
+}
+
+// decode finds the polynomial of degree < k through all but at most e of the
+// points
+func decode(points []pair, k, e int) ([]byte, error) {
+	// unknowns are q_0..q_(e+k-1) followed by e_0..e_(e-1), and each point
+	// gives the row  sum q_j x^j + sum e_j y x^j = y x^e
+	nq := e + k
+	cols := nq + e
+	rows := make([][]byte, len(points))
+	for r, pt := range points {
+		row := make([]byte, cols+1)
+		xj := byte(1)
+		for j := 0; j < nq; j++ {
+			row[j] = xj
+			if j < e {
+				row[nq+j] = mul(pt.y, xj)
+			}
+			if j == e {
+				row[cols] = mul(pt.y, xj)
+			}
+			xj = mul(xj, pt.x)
+		}
+		rows[r] = row
+	}
+
+	solution, ok := solve(rows, cols)
+	if !ok {
+		return nil, ErrTooManyErrors
+	}
+
+	q := solution[:nq]
+	locator := append(append([]byte{}, solution[nq:]...), 1)
+	poly, rest := divmod(q, locator)
+	for _, c := range rest {
+		if c != 0 {
+			return nil, ErrTooManyErrors
+		}
+	}
+	return poly, nil
+}
+
+// solve the augmented system by Gaussian elimination, setting any free
+// variables to zero
+func solve(rows [][]byte, cols int) ([]byte, bool) {
+	pivots := make([]int, 0, cols)
+	r := 0
+	for c := 0; c < cols && r < len(rows); c++ {
+		p := r
+		for p < len(rows) && rows[p][c] == 0 {
+			p++
+		}
+		if p == len(rows) {
+			continue
+		}
+		rows[r], rows[p] = rows[p], rows[r]
+
+		scale := inv(rows[r][c])
+		for j := c; j <= cols; j++ {
+			rows[r][j] = mul(rows[r][j], scale)
+		}
+
+		for i := range rows {
+			if i != r && rows[i][c] != 0 {
+				f := rows[i][c]
+				for j := c; j <= cols; j++ {
+					rows[i][j] ^= mul(f, rows[r][j])
+				}
+			}
+		}
+		pivots = append(pivots, c)
+		r++
+	}
+
+	// any remaining row reads 0 = rhs
+	for i := r; i < len(rows); i++ {
+		if rows[i][cols] != 0 {
+			return nil, false
+		}
+	}
+
+	solution := make([]byte, cols)
+	for i, c := range pivots {
+		solution[c] = rows[i][cols]
+	}
+	return solution, true
+}
+
+// divide n by the monic polynomial d, returning quotient and remainder
+func divmod(n, d []byte) ([]byte, []byte) {
+	rest := append([]byte{}, n...)
+	if len(n) < len(d) {
+		return []byte{0}, rest
+	}
+
+	quotient := make([]byte, len(n)-len(d)+1)
+	for i := len(quotient) - 1; i >= 0; i-- {
+		c := rest[i+degree(d)]
+		quotient[i] = c
+		for j, dj := range d {
+			rest[i+j] ^= mul(c, dj)
+		}
+	}
+	return quotient, rest[:degree(d)]
+}
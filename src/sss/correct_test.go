@@ -0,0 +1,88 @@
+package sss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCombineCorrecting(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := Split(7, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 7 shares with K=3 can correct 2 errors
+	shares[2][0] ^= 0xff
+	shares[6][5] ^= 0x01
+	shares[6][9] ^= 0x80
+
+	actual, bad, err := CombineCorrecting(shares, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	if want := []byte{2, 6}; !bytes.Equal(bad, want) {
+		t.Errorf("Bad shares were %v, but expected %v", bad, want)
+	}
+}
+
+func TestCombineCorrectingNoErrors(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := Split(4, 4, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, bad, err := CombineCorrecting(shares, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) || len(bad) != 0 {
+		t.Errorf("Was %v %v, but expected %v []", actual, bad, secret)
+	}
+}
+
+func TestCombineCorrectingTooManyErrors(t *testing.T) {
+	shares, err := Split(5, 3, []byte("well hello there!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 5 shares with K=3 can only correct 1 error
+	shares[1][0] ^= 1
+	shares[2][1] ^= 1
+
+	if _, _, err := CombineCorrecting(shares, 3); err != ErrTooManyErrors {
+		t.Errorf("Was %v, but expected %v", err, ErrTooManyErrors)
+	}
+}
+
+func TestCombineCorrectingNotEnough(t *testing.T) {
+	shares, err := Split(5, 3, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subset := map[byte][]byte{1: shares[1], 2: shares[2]}
+	if _, _, err := CombineCorrecting(subset, 3); err != ErrNotEnoughShares {
+		t.Errorf("Was %v, but expected %v", err, ErrNotEnoughShares)
+	}
+}
+
+func TestCombineCorrectingLengthMismatch(t *testing.T) {
+	shares, err := Split(5, 3, []byte("well hello there!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shares[4] = shares[4][:3]
+	if _, _, err := CombineCorrecting(shares, 3); err != ErrShareMismatch {
+		t.Errorf("Was %v, but expected %v", err, ErrShareMismatch)
+	}
+}
@@ -1,13 +1,57 @@
 package sss
 
-func mul(e, a byte) byte {
+// Field arithmetic in GF(2^8) with the 0x11b reduction polynomial.
+//
+// mul and div run in constant time: they take the same branches and touch the
+// same memory whatever the (secret) operands are. The table-based mulTable and
+// divTable are kept as a reference and for benchmarks, but index the log and
+// exp tables by their operands and so leak timing information through the
+// cache.
+
+// multiply by shifting and adding, using masks instead of branches
+func mul(e, a byte) (p byte) {
+	for i := 0; i < 8; i++ {
+		p ^= -(a & 1) & e
+		carry := -(e >> 7)
+		e = (e << 1) ^ (0x1b & carry)
+		a >>= 1
+	}
+	return
+}
+
+// the multiplicative inverse, a^254, through a fixed chain of multiplications.
+// inv(0) is 0.
+func inv(a byte) byte {
+	b := mul(a, a)   // a^2
+	c := mul(a, b)   // a^3
+	b = mul(c, c)    // a^6
+	b = mul(b, b)    // a^12
+	c = mul(b, c)    // a^15
+	b = mul(b, b)    // a^24
+	b = mul(b, b)    // a^48
+	b = mul(b, c)    // a^63
+	b = mul(b, b)    // a^126
+	b = mul(a, b)    // a^127
+	return mul(b, b) // a^254
+}
+
+func div(e, a byte) byte {
+	// the divisor is a difference of share IDs, which are public
+	if a == 0 {
+		panic("div by zero")
+	}
+
+	return mul(e, inv(a))
+}
+
+func mulTable(e, a byte) byte {
 	if e == 0 || a == 0 {
 		return 0
 	}
 	return exp[(int(log[e])+int(log[a]))%255]
 }
 
-func div(e, a byte) byte {
+func divTable(e, a byte) byte {
 	if a == 0 {
 		panic("div by zero")
 	}
@@ -33,3 +33,57 @@ func TestDivByZero(t *testing.T) {
 	div(2, 0)
 	t.Error("Shouldn't have been able to divide those")
 }
+
+func TestMulMatchesTable(t *testing.T) {
+	for e := 0; e < fieldSize; e++ {
+		for a := 0; a < fieldSize; a++ {
+			if v, want := mul(byte(e), byte(a)), mulTable(byte(e), byte(a)); v != want {
+				t.Fatalf("mul(%v, %v) was %v, but expected %v", e, a, v, want)
+			}
+		}
+	}
+}
+
+func TestDivMatchesTable(t *testing.T) {
+	for e := 0; e < fieldSize; e++ {
+		for a := 1; a < fieldSize; a++ {
+			if v, want := div(byte(e), byte(a)), divTable(byte(e), byte(a)); v != want {
+				t.Fatalf("div(%v, %v) was %v, but expected %v", e, a, v, want)
+			}
+		}
+	}
+}
+
+func TestInv(t *testing.T) {
+	for a := 1; a < fieldSize; a++ {
+		if v := mul(byte(a), inv(byte(a))); v != 1 {
+			t.Fatalf("%v * inv(%v) was %v, but expected 1", a, a, v)
+		}
+	}
+}
+
+var sink byte
+
+func BenchmarkMul(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink ^= mul(byte(i), byte(i>>8))
+	}
+}
+
+func BenchmarkMulTable(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink ^= mulTable(byte(i), byte(i>>8))
+	}
+}
+
+func BenchmarkDiv(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink ^= div(byte(i), byte(i>>8)|1)
+	}
+}
+
+func BenchmarkDivTable(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink ^= divTable(byte(i), byte(i>>8)|1)
+	}
+}
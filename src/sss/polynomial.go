@@ -49,8 +49,14 @@ type pair struct {
 	x, y byte
 }
 
-// Lagrange interpolation
+// Lagrange interpolation. Runs in constant time with respect to the y values,
+// which are the secret part of the shares.
 func interpolate(points []pair, x byte) (value byte) {
+	return interpolateWith(points, x, mul, div)
+}
+
+// interpolateWith lets the benchmarks compare field implementations
+func interpolateWith(points []pair, x byte, mul, div func(byte, byte) byte) (value byte) {
 	for i, a := range points {
 		weight := byte(1)
 		for j, b := range points {
@@ -87,3 +87,25 @@ func TestInterpolate(t *testing.T) {
 		t.Errorf("Was %v, but expected %v", v, want)
 	}
 }
+
+func benchmarkInterpolate(b *testing.B, mul, div func(byte, byte) byte) {
+	in := []pair{
+		pair{x: 1, y: 17},
+		pair{x: 2, y: 42},
+		pair{x: 3, y: 99},
+		pair{x: 4, y: 7},
+		pair{x: 5, y: 250},
+	}
+
+	for i := 0; i < b.N; i++ {
+		interpolateWith(in, 0, mul, div)
+	}
+}
+
+func BenchmarkInterpolate(b *testing.B) {
+	benchmarkInterpolate(b, mul, div)
+}
+
+func BenchmarkInterpolateTable(b *testing.B) {
+	benchmarkInterpolate(b, mulTable, divTable)
+}
@@ -0,0 +1,128 @@
+package sss
+
+// Ramp secret sharing.
+//
+// A (L, K, N) ramp scheme hides L bytes of the secret in each polynomial instead
+// of one: the L lowest coefficients of a degree K-1 polynomial are secret bytes
+// and the other K-L are random. Each share is then about 1/L the size of the
+// secret. Any K shares recover the secret, fewer than K-L shares reveal nothing
+// about it, and in between some information leaks. L = 1 is plain Shamir.
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sort"
+)
+
+var (
+	// ErrInvalidRamp is returned when L is not between 1 and K-1.
+	ErrInvalidRamp = errors.New("L must be >= 1 and < K")
+	// ErrRampLength is returned when the secret length doesn't fit the
+	// number of blocks in the shares.
+	ErrRampLength = errors.New("secret length doesn't match the shares")
+)
+
+// SplitRamp splits the given secret into N shares of which K are required to
+// recover it, hiding L bytes of the secret in each byte of a share.
+func SplitRamp(n, k, l byte, secret []byte) (map[byte][]byte, error) {
+	if err := checkRamp(n, k, l); err != nil {
+		return nil, err
+	}
+
+	blocks := (len(secret) + int(l) - 1) / int(l)
+	shares := make(map[byte][]byte, n)
+	for x := byte(1); x <= n; x++ {
+		shares[x] = make([]byte, 0, blocks)
+	}
+
+	p := make([]byte, k)
+	for b := 0; b < blocks; b++ {
+		for i := range p[:l] {
+			p[i] = 0
+		}
+		copy(p[:l], secret[b*int(l):])
+		if _, err := io.ReadFull(rand.Reader, p[l:]); err != nil {
+			return nil, err
+		}
+
+		for x := byte(1); x <= n; x++ {
+			shares[x] = append(shares[x], eval(p, x))
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineRamp combines K or more shares made by SplitRamp with the same K and
+// L into the original secret of the given length.
+func CombineRamp(shares map[byte][]byte, k, l byte, length int) ([]byte, error) {
+	if err := checkRamp(k, k, l); err != nil {
+		return nil, err
+	}
+
+	if len(shares) < int(k) {
+		return nil, ErrNotEnoughShares
+	}
+
+	xs := make([]byte, 0, len(shares))
+	for x := range shares {
+		if x == 0 {
+			return nil, ErrInvalidShareID
+		}
+		xs = append(xs, x)
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i] < xs[j] })
+	xs = xs[:k]
+
+	blocks := len(shares[xs[0]])
+	for _, x := range xs {
+		if len(shares[x]) != blocks {
+			return nil, ErrShareMismatch
+		}
+	}
+
+	// SplitRamp pads the secret to a whole number of blocks
+	if length < 0 || length > blocks*int(l) || (blocks > 0 && length <= (blocks-1)*int(l)) {
+		return nil, ErrRampLength
+	}
+
+	secret := make([]byte, 0, blocks*int(l))
+	for b := 0; b < blocks; b++ {
+		// the Vandermonde system  sum p_j x^j = y  for the K shares
+		rows := make([][]byte, k)
+		for r, x := range xs {
+			row := make([]byte, int(k)+1)
+			xj := byte(1)
+			for j := 0; j < int(k); j++ {
+				row[j] = xj
+				xj = mul(xj, x)
+			}
+			row[k] = shares[x][b]
+			rows[r] = row
+		}
+
+		p, ok := solve(rows, int(k))
+		if !ok {
+			return nil, ErrInvalidShareID
+		}
+		secret = append(secret, p[:l]...)
+	}
+
+	return secret[:length], nil
+}
+
+func checkRamp(n, k, l byte) error {
+	if k <= 1 {
+		return ErrInvalidThreshold
+	}
+
+	if k > n {
+		return ErrThresholdTooLarge
+	}
+
+	if l < 1 || l >= k {
+		return ErrInvalidRamp
+	}
+	return nil
+}
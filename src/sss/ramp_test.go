@@ -0,0 +1,88 @@
+package sss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRampRoundtrip(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := SplitRamp(6, 4, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 17 bytes in blocks of 3
+	if v, want := len(shares[1]), 6; v != want {
+		t.Errorf("Share was %v bytes, but expected %v", v, want)
+	}
+
+	subset := map[byte][]byte{2: shares[2], 3: shares[3], 5: shares[5], 6: shares[6]}
+	actual, err := CombineRamp(subset, 4, 3, len(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+}
+
+func TestRampNotEnoughShares(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := SplitRamp(6, 4, 2, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delete(shares, 1)
+	delete(shares, 2)
+	delete(shares, 3)
+	if _, err := CombineRamp(shares, 4, 2, len(secret)); err != ErrNotEnoughShares {
+		t.Errorf("Was %v, but expected %v", err, ErrNotEnoughShares)
+	}
+}
+
+func TestRampInvalidParameters(t *testing.T) {
+	cases := []struct {
+		n, k, l byte
+		err     error
+	}{
+		{5, 1, 1, ErrInvalidThreshold},
+		{3, 4, 2, ErrThresholdTooLarge},
+		{5, 3, 0, ErrInvalidRamp},
+		{5, 3, 3, ErrInvalidRamp},
+	}
+
+	for _, c := range cases {
+		if _, err := SplitRamp(c.n, c.k, c.l, []byte("secret")); err != c.err {
+			t.Errorf("SplitRamp(%v, %v, %v) was %v, but expected %v", c.n, c.k, c.l, err, c.err)
+		}
+	}
+}
+
+func TestRampInvalidLength(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := SplitRamp(6, 4, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 6 blocks of 3 hold 16 to 18 bytes
+	for _, length := range []int{-1, 0, 15, 19, 1000} {
+		if _, err := CombineRamp(shares, 4, 3, length); err != ErrRampLength {
+			t.Errorf("Length %v was %v, but expected %v", length, err, ErrRampLength)
+		}
+	}
+
+	for _, length := range []int{16, 18} {
+		if _, err := CombineRamp(shares, 4, 3, length); err != nil {
+			t.Errorf("Length %v was %v, but expected no error", length, err)
+		}
+	}
+
+	shares[2] = shares[2][:5]
+	if _, err := CombineRamp(shares, 4, 3, len(secret)); err != ErrShareMismatch {
+		t.Errorf("Was %v, but expected %v", err, ErrShareMismatch)
+	}
+}
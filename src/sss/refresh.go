@@ -0,0 +1,129 @@
+package sss
+
+// Proactive share refresh.
+//
+// Adding a polynomial whose constant term is zero to every share produces a new
+// sharing of the same secret: interpolating at x = 0 gives secret + 0. Since the
+// update polynomial is random, the refreshed shares are independent of the old
+// ones, and mixing old and refreshed shares recovers garbage. No one needs to
+// reassemble the secret to refresh it.
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+// ErrShareLength is returned when a share and its update don't match in size.
+var ErrShareLength = errors.New("share and update lengths differ")
+
+// NewRefresh generates updates for the N shares of a secret of the given length
+// which was split with threshold K. Each holder passes its share and the update
+// with the same ID to ApplyRefresh.
+func NewRefresh(n, k byte, length int) (map[byte][]byte, error) {
+	if n <= 2 {
+		return nil, ErrInvalidCount
+	}
+
+	if k <= 1 {
+		return nil, ErrInvalidThreshold
+	}
+
+	if k > n {
+		return nil, ErrThresholdTooLarge
+	}
+
+	updates := make(map[byte][]byte, n)
+	for x := byte(1); x <= n; x++ {
+		updates[x] = make([]byte, 0, length)
+	}
+
+	for i := 0; i < length; i++ {
+		p, err := generate(k-1, 0, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		for x := byte(1); x <= n; x++ {
+			updates[x] = append(updates[x], eval(p, x))
+		}
+	}
+
+	return updates, nil
+}
+
+// ApplyRefresh returns the refreshed share. Addition in GF(2^8) is XOR.
+func ApplyRefresh(share, update []byte) ([]byte, error) {
+	if len(share) != len(update) {
+		return nil, ErrShareLength
+	}
+
+	refreshed := make([]byte, len(share))
+	for i := range share {
+		refreshed[i] = share[i] ^ update[i]
+	}
+	return refreshed, nil
+}
+
+// NewRefreshVerifiable generates updates for N shares made by SplitVerifiable,
+// along with the commitments the refreshed shares verify against.
+func NewRefreshVerifiable(n byte, c *Commitments) (map[byte][]byte, *Commitments, error) {
+	if n <= 2 {
+		return nil, nil, ErrInvalidCount
+	}
+
+	if c.Threshold > n {
+		return nil, nil, ErrThresholdTooLarge
+	}
+
+	refreshed := &Commitments{Threshold: c.Threshold, Length: c.Length}
+	updates := make(map[byte][]byte, n)
+
+	for _, commits := range c.Values {
+		if len(commits) != int(c.Threshold) {
+			return nil, nil, ErrInvalidShare
+		}
+
+		coeffs := make([]*big.Int, c.Threshold)
+		coeffs[0] = new(big.Int)
+		for j := 1; j < len(coeffs); j++ {
+			a, err := rand.Int(rand.Reader, vssQ)
+			if err != nil {
+				return nil, nil, err
+			}
+			coeffs[j] = a
+		}
+
+		// C'_j = C_j * g^d_j, and g^0 = 1 leaves C_0 alone
+		next := make([]*big.Int, len(commits))
+		for j, a := range coeffs {
+			d := new(big.Int).Exp(vssG, a, vssP)
+			next[j] = d.Mul(d, commits[j]).Mod(d, vssP)
+		}
+		refreshed.Values = append(refreshed.Values, next)
+
+		for x := byte(1); x <= n; x++ {
+			y := evalQ(coeffs, big.NewInt(int64(x)))
+			updates[x] = append(updates[x], y.FillBytes(make([]byte, vssValueSize))...)
+		}
+	}
+
+	return updates, refreshed, nil
+}
+
+// ApplyRefreshVerifiable returns the refreshed share, adding each chunk of the
+// update mod q.
+func ApplyRefreshVerifiable(share, update []byte) ([]byte, error) {
+	if len(share) != len(update) || len(share)%vssValueSize != 0 {
+		return nil, ErrShareLength
+	}
+
+	refreshed := make([]byte, 0, len(share))
+	for i := 0; i < len(share); i += vssValueSize {
+		y := new(big.Int).SetBytes(share[i : i+vssValueSize])
+		d := new(big.Int).SetBytes(update[i : i+vssValueSize])
+		y.Add(y, d).Mod(y, vssQ)
+		refreshed = append(refreshed, y.FillBytes(make([]byte, vssValueSize))...)
+	}
+	return refreshed, nil
+}
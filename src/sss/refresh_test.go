@@ -0,0 +1,81 @@
+package sss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRefresh(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := Split(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, err := NewRefresh(5, 3, len(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := make(map[byte][]byte, len(shares))
+	for x, share := range shares {
+		if refreshed[x], err = ApplyRefresh(share, updates[x]); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(refreshed[x], share) {
+			t.Errorf("Share %v wasn't changed", x)
+		}
+	}
+
+	subset := map[byte][]byte{1: refreshed[1], 3: refreshed[3], 4: refreshed[4]}
+	if actual := Combine(subset); !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	// old shares no longer combine with new ones
+	mixed := map[byte][]byte{1: shares[1], 3: refreshed[3], 4: refreshed[4]}
+	if actual := Combine(mixed); bytes.Equal(actual, secret) {
+		t.Error("Old and refreshed shares shouldn't combine")
+	}
+}
+
+func TestApplyRefreshLength(t *testing.T) {
+	if _, err := ApplyRefresh([]byte{1, 2}, []byte{1}); err != ErrShareLength {
+		t.Errorf("Was %v, but expected %v", err, ErrShareLength)
+	}
+}
+
+func TestRefreshVerifiable(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, c, err := SplitVerifiable(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, c2, err := NewRefreshVerifiable(5, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := make(map[byte][]byte, len(shares))
+	for x, share := range shares {
+		if refreshed[x], err = ApplyRefreshVerifiable(share, updates[x]); err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyShare(x, refreshed[x], c2) {
+			t.Errorf("Refreshed share %v should verify", x)
+		}
+		if VerifyShare(x, share, c2) {
+			t.Errorf("Old share %v shouldn't verify", x)
+		}
+	}
+
+	actual, err := CombineVerifiable(refreshed, c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+}
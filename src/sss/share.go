@@ -0,0 +1,165 @@
+package sss
+
+// Self-describing share encoding.
+//
+// A marshalled share is laid out as follows, with integers in big-endian order:
+//
+//     version    1 byte
+//     ID         1 byte     (the share's x coordinate)
+//     threshold  1 byte     (K)
+//     count      1 byte     (N)
+//     length     4 bytes    (length of the secret)
+//     secret ID 16 bytes    (random, the same for all shares of one split)
+//     value      remaining bytes
+//     checksum   4 bytes    (CRC-32 of everything before it)
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// ShareVersion is the version of the encoding produced by Marshal.
+const ShareVersion = 1
+
+const (
+	shareHeaderSize   = 1 + 1 + 1 + 1 + 4 + 16
+	shareChecksumSize = 4
+)
+
+var (
+	// ErrShareFormat is returned when an encoded share is too short.
+	ErrShareFormat = errors.New("share is truncated")
+	// ErrShareVersion is returned when an encoded share has an unknown version.
+	ErrShareVersion = errors.New("unknown share version")
+	// ErrShareChecksum is returned when an encoded share fails its checksum.
+	ErrShareChecksum = errors.New("share checksum mismatch")
+	// ErrShareMismatch is returned when shares come from different splits,
+	// disagree on their parameters, or differ in length, e.g. because one
+	// was truncated.
+	ErrShareMismatch = errors.New("shares are from different splits or differ in length")
+	// ErrDuplicateShare is returned when two shares have the same ID.
+	ErrDuplicateShare = errors.New("duplicate share ID")
+)
+
+// Share is one share along with the parameters of the split it came from.
+type Share struct {
+	ID        byte
+	Threshold byte
+	Count     byte
+	Length    uint32
+	SecretID  [16]byte
+	Value     []byte
+}
+
+// Marshal encodes the share.
+func (s *Share) Marshal() []byte {
+	b := make([]byte, shareHeaderSize, shareHeaderSize+len(s.Value)+shareChecksumSize)
+	b[0] = ShareVersion
+	b[1] = s.ID
+	b[2] = s.Threshold
+	b[3] = s.Count
+	binary.BigEndian.PutUint32(b[4:8], s.Length)
+	copy(b[8:shareHeaderSize], s.SecretID[:])
+	b = append(b, s.Value...)
+
+	sum := make([]byte, shareChecksumSize)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(b))
+	return append(b, sum...)
+}
+
+// ParseShare decodes a share encoded by Marshal.
+func ParseShare(b []byte) (*Share, error) {
+	if len(b) < shareHeaderSize+shareChecksumSize {
+		return nil, ErrShareFormat
+	}
+
+	if b[0] != ShareVersion {
+		return nil, ErrShareVersion
+	}
+
+	body := b[:len(b)-shareChecksumSize]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(b[len(body):]) {
+		return nil, ErrShareChecksum
+	}
+
+	s := &Share{
+		ID:        b[1],
+		Threshold: b[2],
+		Count:     b[3],
+		Length:    binary.BigEndian.Uint32(b[4:8]),
+		Value:     append([]byte(nil), body[shareHeaderSize:]...),
+	}
+	copy(s.SecretID[:], b[8:shareHeaderSize])
+
+	if s.ID == 0 {
+		return nil, ErrInvalidShareID
+	}
+	return s, nil
+}
+
+// SplitShares is like Split, but returns self-describing shares tagged with a
+// random secret ID.
+func SplitShares(n, k byte, secret []byte) ([]Share, error) {
+	values, err := Split(n, k, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var id [16]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, err
+	}
+
+	shares := make([]Share, 0, n)
+	for x := byte(1); x <= n; x++ {
+		shares = append(shares, Share{
+			ID:        x,
+			Threshold: k,
+			Count:     n,
+			Length:    uint32(len(secret)),
+			SecretID:  id,
+			Value:     values[x],
+		})
+	}
+	return shares, nil
+}
+
+// CombineShares combines shares made by SplitShares. Unlike Combine, it rejects
+// shares from different splits or with mismatched parameters, and requires at
+// least K of them.
+func CombineShares(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrNotEnoughShares
+	}
+
+	first := shares[0]
+	values := make(map[byte][]byte, len(shares))
+	for _, s := range shares {
+		if s.SecretID != first.SecretID || s.Threshold != first.Threshold ||
+			s.Count != first.Count || s.Length != first.Length {
+			return nil, ErrShareMismatch
+		}
+
+		if s.ID == 0 || s.ID > s.Count {
+			return nil, ErrInvalidShareID
+		}
+
+		if len(s.Value) != int(s.Length) {
+			return nil, ErrShareMismatch
+		}
+
+		if _, ok := values[s.ID]; ok {
+			return nil, ErrDuplicateShare
+		}
+		values[s.ID] = s.Value
+	}
+
+	if len(values) < int(first.Threshold) {
+		return nil, ErrNotEnoughShares
+	}
+
+	return Combine(values), nil
+}
@@ -0,0 +1,106 @@
+package sss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShareMarshalRoundtrip(t *testing.T) {
+	s := Share{ID: 3, Threshold: 2, Count: 5, Length: 4, Value: []byte{1, 2, 3, 4}}
+	s.SecretID[0] = 42
+
+	actual, err := ParseShare(s.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual.ID != s.ID || actual.Threshold != s.Threshold || actual.Count != s.Count ||
+		actual.Length != s.Length || actual.SecretID != s.SecretID || !bytes.Equal(actual.Value, s.Value) {
+		t.Errorf("Was %v, but expected %v", actual, s)
+	}
+}
+
+func TestParseShareErrors(t *testing.T) {
+	s := Share{ID: 1, Threshold: 2, Count: 3, Length: 1, Value: []byte{9}}
+	b := s.Marshal()
+
+	corrupt := append([]byte{}, b...)
+	corrupt[len(corrupt)-5] ^= 1
+	if _, err := ParseShare(corrupt); err != ErrShareChecksum {
+		t.Errorf("Was %v, but expected %v", err, ErrShareChecksum)
+	}
+
+	version := append([]byte{}, b...)
+	version[0] = 2
+	if _, err := ParseShare(version); err != ErrShareVersion {
+		t.Errorf("Was %v, but expected %v", err, ErrShareVersion)
+	}
+
+	if _, err := ParseShare(b[:10]); err != ErrShareFormat {
+		t.Errorf("Was %v, but expected %v", err, ErrShareFormat)
+	}
+}
+
+func TestCombineShares(t *testing.T) {
+	secret := []byte("well hello there!")
+	shares, err := SplitShares(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed []Share
+	for _, s := range shares[1:4] {
+		p, err := ParseShare(s.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, *p)
+	}
+
+	actual, err := CombineShares(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	if _, err := CombineShares(parsed[:2]); err != ErrNotEnoughShares {
+		t.Errorf("Was %v, but expected %v", err, ErrNotEnoughShares)
+	}
+
+	dup := append([]Share{}, parsed[0], parsed[0], parsed[1])
+	if _, err := CombineShares(dup); err != ErrDuplicateShare {
+		t.Errorf("Was %v, but expected %v", err, ErrDuplicateShare)
+	}
+}
+
+func TestCombineSharesMismatch(t *testing.T) {
+	secret := []byte("well hello there!")
+	a, err := SplitShares(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := SplitShares(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mixed := []Share{a[0], a[1], b[2]}
+	if _, err := CombineShares(mixed); err != ErrShareMismatch {
+		t.Errorf("Was %v, but expected %v", err, ErrShareMismatch)
+	}
+
+	a[2].Threshold = 2
+	if _, err := CombineShares(a[:3]); err != ErrShareMismatch {
+		t.Errorf("Was %v, but expected %v", err, ErrShareMismatch)
+	}
+
+	a[2].Threshold = 3
+	a[2].Value = a[2].Value[:len(a[2].Value)-1]
+	if _, err := CombineShares(a[:3]); err != ErrShareMismatch {
+		t.Errorf("Was %v, but expected %v", err, ErrShareMismatch)
+	}
+}
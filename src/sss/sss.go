@@ -61,6 +61,15 @@ func Split(n, k byte, secret []byte) (map[byte][]byte, error) {
 		return nil, ErrInvalidThreshold
 	}
 
+	if k > n {
+		return nil, ErrThresholdTooLarge
+	}
+
+	return split(n, k, secret)
+}
+
+// split without checking the parameters
+func split(n, k byte, secret []byte) (map[byte][]byte, error) {
 	shares := make(map[byte][]byte, n)
 
 	for _, b := range secret {
@@ -0,0 +1,129 @@
+package sss
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// streamBufferSize is how many bytes of the secret are handled at a time.
+const streamBufferSize = 32 * 1024
+
+// ErrInvalidShareID is returned when a share ID is zero, which would be the
+// secret itself.
+var ErrInvalidShareID = errors.New("share IDs must be 1-255")
+
+// SplitStream reads a secret from r until EOF and splits it into one share per
+// writer, keyed by share ID, of which K are required to recover the secret.
+// Only a small buffer of the secret is held in memory at a time.
+func SplitStream(k byte, r io.Reader, shares map[byte]io.Writer) error {
+	if len(shares) <= 2 {
+		return ErrInvalidCount
+	}
+
+	if k <= 1 {
+		return ErrInvalidThreshold
+	}
+
+	if int(k) > len(shares) {
+		return ErrThresholdTooLarge
+	}
+
+	for x := range shares {
+		if x == 0 {
+			return ErrInvalidShareID
+		}
+	}
+
+	random := bufio.NewReader(rand.Reader)
+	in := make([]byte, streamBufferSize)
+	out := make(map[byte][]byte, len(shares))
+	for x := range shares {
+		out[x] = make([]byte, streamBufferSize)
+	}
+
+	for {
+		n, err := io.ReadFull(r, in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		for i, b := range in[:n] {
+			p, err := generate(k-1, b, random)
+			if err != nil {
+				return err
+			}
+
+			for x := range shares {
+				out[x][i] = eval(p, x)
+			}
+		}
+
+		for x, w := range shares {
+			if _, err := w.Write(out[x][:n]); err != nil {
+				return err
+			}
+		}
+
+		if n < len(in) {
+			return nil
+		}
+	}
+}
+
+// CombineStream reads share streams, keyed by share ID, until EOF and writes
+// the combined secret to w. All streams must have the same length.
+//
+// N.B.: As with Combine, there is no way to know whether the output is, in
+// fact, the original secret.
+func CombineStream(shares map[byte]io.Reader, w io.Writer) error {
+	for x := range shares {
+		if x == 0 {
+			return ErrInvalidShareID
+		}
+	}
+
+	in := make(map[byte][]byte, len(shares))
+	for x := range shares {
+		in[x] = make([]byte, streamBufferSize)
+	}
+	out := make([]byte, streamBufferSize)
+	points := make([]pair, len(shares))
+
+	for {
+		n := -1
+		for x, r := range shares {
+			m, err := io.ReadFull(r, in[x])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			if n >= 0 && m != n {
+				return ErrShareMismatch
+			}
+			n = m
+		}
+
+		for i := 0; i < n; i++ {
+			p := 0
+			for x := range shares {
+				points[p] = pair{x: x, y: in[x][i]}
+				p++
+			}
+			out[i] = interpolate(points, 0)
+		}
+
+		if n > 0 {
+			if _, err := w.Write(out[:n]); err != nil {
+				return err
+			}
+		}
+
+		if n < len(out) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sss
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStreamRoundtrip(t *testing.T) {
+	// spans several buffers and ends in a partial one
+	secret := bytes.Repeat([]byte("well hello there!"), 5000)
+
+	bufs := make(map[byte]*bytes.Buffer)
+	writers := make(map[byte]io.Writer)
+	for x := byte(1); x <= 5; x++ {
+		bufs[x] = new(bytes.Buffer)
+		writers[x] = bufs[x]
+	}
+
+	if err := SplitStream(3, bytes.NewReader(secret), writers); err != nil {
+		t.Fatal(err)
+	}
+
+	for x, b := range bufs {
+		if b.Len() != len(secret) {
+			t.Errorf("Share %v was %v bytes, but expected %v", x, b.Len(), len(secret))
+		}
+	}
+
+	readers := map[byte]io.Reader{1: bufs[1], 2: bufs[2], 5: bufs[5]}
+	out := new(bytes.Buffer)
+	if err := CombineStream(readers, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), secret) {
+		t.Error("Combined stream didn't match the secret")
+	}
+}
+
+func TestStreamMatchesCombine(t *testing.T) {
+	secret := []byte("well hello there!")
+
+	bufs := make(map[byte]*bytes.Buffer)
+	writers := make(map[byte]io.Writer)
+	for x := byte(1); x <= 3; x++ {
+		bufs[x] = new(bytes.Buffer)
+		writers[x] = bufs[x]
+	}
+
+	if err := SplitStream(2, bytes.NewReader(secret), writers); err != nil {
+		t.Fatal(err)
+	}
+
+	shares := map[byte][]byte{1: bufs[1].Bytes(), 3: bufs[3].Bytes()}
+	if actual := Combine(shares); !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+}
+
+func TestCombineStreamLengthMismatch(t *testing.T) {
+	readers := map[byte]io.Reader{
+		1: bytes.NewReader([]byte{1, 2, 3}),
+		2: bytes.NewReader([]byte{1, 2}),
+	}
+
+	if err := CombineStream(readers, new(bytes.Buffer)); err != ErrShareMismatch {
+		t.Errorf("Was %v, but expected %v", err, ErrShareMismatch)
+	}
+}
+
+func TestSplitStreamInvalidID(t *testing.T) {
+	writers := map[byte]io.Writer{
+		0: new(bytes.Buffer),
+		1: new(bytes.Buffer),
+		2: new(bytes.Buffer),
+	}
+
+	if err := SplitStream(2, bytes.NewReader([]byte("x")), writers); err != ErrInvalidShareID {
+		t.Errorf("Was %v, but expected %v", err, ErrInvalidShareID)
+	}
+}
@@ -0,0 +1,228 @@
+package sss
+
+// Feldman's verifiable secret sharing.
+//
+// The GF(2^8) arithmetic used by Split can't support commitments, since
+// discrete logarithms in such a small field are trivial. Instead, the secret is
+// cut into chunks which are shared with polynomials over Z_q, where q is the
+// order of a prime-order subgroup of Z_p^* generated by g. The dealer publishes
+// g^a mod p for every coefficient a of every polynomial, and a holder of the
+// share (x, y) can check that g^y equals the product of C_j^(x^j) without
+// learning anything else.
+//
+// The commitment to the constant term is g^secret, so this scheme is only
+// computationally hiding: it is meant for high-entropy secrets such as keys.
+//
+// Vanish doesn't use it. A VDO keeps the SHA-256 hash of each share instead,
+// which is enough to discard bad shares and reveals nothing about the key.
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+var (
+	// ErrThresholdTooLarge is returned when K is larger than N.
+	ErrThresholdTooLarge = errors.New("K must be <= N")
+	// ErrInvalidShare is returned when a share does not match its commitments.
+	ErrInvalidShare = errors.New("share does not match commitments")
+	// ErrNotEnoughShares is returned when fewer than K shares are usable.
+	ErrNotEnoughShares = errors.New("not enough valid shares")
+)
+
+var (
+	// 2048-bit MODP group from RFC 3526. p is a safe prime, and 2 generates
+	// its subgroup of prime order q = (p-1)/2.
+	vssP, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+			"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+			"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+			"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+			"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+			"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+			"83655D23DCA3AD961C62F356208552BB9ED529077096966D"+
+			"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+			"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"+
+			"DE2BCBF6955817183995497CEA956AE515D2261898FA0510"+
+			"15728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+	vssQ = new(big.Int).Rsh(vssP, 1)
+	vssG = big.NewInt(2)
+
+	// every chunk must be smaller than q
+	vssChunkSize = (vssQ.BitLen() - 1) / 8
+	// shares encode each chunk's y value in this many bytes
+	vssValueSize = (vssP.BitLen() + 7) / 8
+)
+
+// Commitments are the public values a share is checked against. Values[c][j]
+// is g^a mod p for coefficient j of the polynomial sharing chunk c.
+type Commitments struct {
+	Threshold byte
+	Length    int
+	Values    [][]*big.Int
+}
+
+// SplitVerifiable splits the given secret into N shares of which K are required
+// to recover the secret, like Split, but also returns the public commitments
+// which VerifyShare checks each share against.
+func SplitVerifiable(n, k byte, secret []byte) (map[byte][]byte, *Commitments, error) {
+	if n <= 2 {
+		return nil, nil, ErrInvalidCount
+	}
+
+	if k <= 1 {
+		return nil, nil, ErrInvalidThreshold
+	}
+
+	if k > n {
+		return nil, nil, ErrThresholdTooLarge
+	}
+
+	c := &Commitments{Threshold: k, Length: len(secret)}
+	shares := make(map[byte][]byte, n)
+
+	for start := 0; start < len(secret); start += vssChunkSize {
+		end := start + vssChunkSize
+		if end > len(secret) {
+			end = len(secret)
+		}
+
+		coeffs := make([]*big.Int, k)
+		coeffs[0] = new(big.Int).SetBytes(secret[start:end])
+		for j := 1; j < int(k); j++ {
+			a, err := rand.Int(rand.Reader, vssQ)
+			if err != nil {
+				return nil, nil, err
+			}
+			coeffs[j] = a
+		}
+
+		commits := make([]*big.Int, k)
+		for j, a := range coeffs {
+			commits[j] = new(big.Int).Exp(vssG, a, vssP)
+		}
+		c.Values = append(c.Values, commits)
+
+		for x := byte(1); x <= n; x++ {
+			y := evalQ(coeffs, big.NewInt(int64(x)))
+			shares[x] = append(shares[x], y.FillBytes(make([]byte, vssValueSize))...)
+		}
+	}
+
+	return shares, c, nil
+}
+
+// VerifyShare reports whether the share with ID x is consistent with the
+// commitments.
+func VerifyShare(x byte, share []byte, c *Commitments) bool {
+	if x == 0 || c == nil || len(share) != len(c.Values)*vssValueSize {
+		return false
+	}
+
+	bx := big.NewInt(int64(x))
+	for i, commits := range c.Values {
+		if len(commits) != int(c.Threshold) {
+			return false
+		}
+
+		y := new(big.Int).SetBytes(share[i*vssValueSize : (i+1)*vssValueSize])
+		if y.Cmp(vssQ) >= 0 {
+			return false
+		}
+
+		// g^y == C_0 * C_1^x * C_2^(x^2) * ...
+		want := big.NewInt(1)
+		xj := big.NewInt(1)
+		for _, cj := range commits {
+			t := new(big.Int).Exp(cj, xj, vssP)
+			want.Mul(want, t).Mod(want, vssP)
+			xj.Mul(xj, bx).Mod(xj, vssQ)
+		}
+
+		if new(big.Int).Exp(vssG, y, vssP).Cmp(want) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CombineVerifiable discards the shares which fail VerifyShare and combines K
+// of the rest into the original secret. It returns ErrNotEnoughShares if fewer
+// than K shares are valid, and ErrInvalidShare if the commitments don't cover
+// exactly Length bytes.
+func CombineVerifiable(shares map[byte][]byte, c *Commitments) ([]byte, error) {
+	if c == nil {
+		return nil, ErrNotEnoughShares
+	}
+
+	// the commitments may come from the same untrusted place as the shares
+	if c.Length < 0 || len(c.Values) != (c.Length+vssChunkSize-1)/vssChunkSize {
+		return nil, ErrInvalidShare
+	}
+
+	var xs []byte
+	for x, share := range shares {
+		if VerifyShare(x, share, c) {
+			xs = append(xs, x)
+			if len(xs) == int(c.Threshold) {
+				break
+			}
+		}
+	}
+
+	if len(xs) < int(c.Threshold) {
+		return nil, ErrNotEnoughShares
+	}
+
+	secret := make([]byte, 0, c.Length)
+	for i := range c.Values {
+		size := vssChunkSize
+		if rest := c.Length - i*vssChunkSize; rest < size {
+			size = rest
+		}
+
+		points := make([][2]*big.Int, len(xs))
+		for p, x := range xs {
+			y := shares[x][i*vssValueSize : (i+1)*vssValueSize]
+			points[p] = [2]*big.Int{big.NewInt(int64(x)), new(big.Int).SetBytes(y)}
+		}
+
+		chunk := interpolateQ(points)
+		if chunk.BitLen() > size*8 {
+			return nil, ErrInvalidShare
+		}
+		secret = append(secret, chunk.FillBytes(make([]byte, size))...)
+	}
+
+	return secret, nil
+}
+
+// evaluate the polynomial at the given point, mod q
+func evalQ(coeffs []*big.Int, x *big.Int) *big.Int {
+	// Horner's scheme
+	result := new(big.Int)
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		result.Mul(result, x).Add(result, coeffs[i]).Mod(result, vssQ)
+	}
+	return result
+}
+
+// Lagrange interpolation at x = 0, mod q
+func interpolateQ(points [][2]*big.Int) *big.Int {
+	value := new(big.Int)
+	for i, a := range points {
+		num := big.NewInt(1)
+		den := big.NewInt(1)
+		for j, b := range points {
+			if i != j {
+				num.Mul(num, b[0]).Mod(num, vssQ)
+				t := new(big.Int).Sub(b[0], a[0])
+				den.Mul(den, t).Mod(den, vssQ)
+			}
+		}
+		weight := num.Mul(num, den.ModInverse(den, vssQ))
+		value.Add(value, weight.Mul(weight, a[1])).Mod(value, vssQ)
+	}
+	return value
+}
@@ -0,0 +1,102 @@
+package sss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitVerifiableRoundtrip(t *testing.T) {
+	// longer than one chunk, with leading zeros
+	secret := append([]byte{0, 0, 1}, bytes.Repeat([]byte("secret"), 60)...)
+
+	shares, c, err := SplitVerifiable(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for x, share := range shares {
+		if !VerifyShare(x, share, c) {
+			t.Errorf("Share %v should verify", x)
+		}
+	}
+
+	subset := map[byte][]byte{2: shares[2], 4: shares[4], 5: shares[5]}
+	actual, err := CombineVerifiable(subset, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+}
+
+func TestVerifyShareTampered(t *testing.T) {
+	shares, c, err := SplitVerifiable(5, 3, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := append([]byte{}, shares[1]...)
+	bad[len(bad)-1] ^= 1
+	if VerifyShare(1, bad, c) {
+		t.Error("Tampered share shouldn't verify")
+	}
+
+	if VerifyShare(2, shares[1], c) {
+		t.Error("Share shouldn't verify under another ID")
+	}
+
+	if VerifyShare(1, shares[1][1:], c) {
+		t.Error("Truncated share shouldn't verify")
+	}
+}
+
+func TestCombineVerifiableSkipsBadShares(t *testing.T) {
+	secret := []byte("secret")
+	shares, c, err := SplitVerifiable(5, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shares[1][0] ^= 1
+	shares[3] = []byte{1, 2, 3}
+	actual, err := CombineVerifiable(shares, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	shares[5][0] ^= 1
+	if _, err := CombineVerifiable(shares, c); err != ErrNotEnoughShares {
+		t.Errorf("Was %v, but expected %v", err, ErrNotEnoughShares)
+	}
+}
+
+func TestCombineVerifiableBadCommitments(t *testing.T) {
+	shares, c, err := SplitVerifiable(5, 3, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, length := range []int{-1, 0, vssChunkSize + 1, 1 << 30} {
+		bad := *c
+		bad.Length = length
+		if _, err := CombineVerifiable(shares, &bad); err != ErrInvalidShare {
+			t.Errorf("Was %v, but expected %v", err, ErrInvalidShare)
+		}
+	}
+
+	if _, err := CombineVerifiable(shares, nil); err != ErrNotEnoughShares {
+		t.Errorf("Was %v, but expected %v", err, ErrNotEnoughShares)
+	}
+}
+
+func TestSplitVerifiableThresholdTooLarge(t *testing.T) {
+	if _, _, err := SplitVerifiable(3, 4, []byte("secret")); err != ErrThresholdTooLarge {
+		t.Errorf("Was %v, but expected %v", err, ErrThresholdTooLarge)
+	}
+}
@@ -0,0 +1,67 @@
+package sss
+
+import "errors"
+
+var (
+	// ErrInvalidWeight is returned when a holder has a weight of zero.
+	ErrInvalidWeight = errors.New("weights must be >= 1")
+	// ErrTooManyShares is returned when the weights add up to more than 255.
+	ErrTooManyShares = errors.New("total weight must be <= 255")
+)
+
+// SplitWeighted splits the given secret between holders, giving each holder as
+// many shares as its weight. Any group of holders whose weights add up to K or
+// more can recover the secret with CombineWeighted. Returns each holder's
+// shares, in the order of weights.
+func SplitWeighted(weights []byte, k byte, secret []byte) ([]map[byte][]byte, error) {
+	if k <= 1 {
+		return nil, ErrInvalidThreshold
+	}
+
+	total := 0
+	for _, w := range weights {
+		if w == 0 {
+			return nil, ErrInvalidWeight
+		}
+		total += int(w)
+	}
+
+	if total > 255 {
+		return nil, ErrTooManyShares
+	}
+
+	if int(k) > total {
+		return nil, ErrThresholdTooLarge
+	}
+
+	shares, err := split(byte(total), k, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := make([]map[byte][]byte, len(weights))
+	x := byte(1)
+	for h, w := range weights {
+		holders[h] = make(map[byte][]byte, w)
+		for i := byte(0); i < w; i++ {
+			holders[h][x] = shares[x]
+			x++
+		}
+	}
+	return holders, nil
+}
+
+// CombineWeighted combines the shares of several holders into the original
+// secret.
+//
+// N.B.: As with Combine, there is no way to know whether the returned value is,
+// in fact, the original secret.
+func CombineWeighted(holders []map[byte][]byte) []byte {
+	shares := make(map[byte][]byte)
+	for _, h := range holders {
+		for x, v := range h {
+			shares[x] = v
+		}
+	}
+	return Combine(shares)
+}
@@ -0,0 +1,52 @@
+package sss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWeighted(t *testing.T) {
+	secret := []byte("well hello there!")
+	holders, err := SplitWeighted([]byte{3, 1, 1, 1}, 3, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, want := len(holders[0]), 3; v != want {
+		t.Errorf("Holder had %v shares, but expected %v", v, want)
+	}
+
+	// the heavy holder alone is enough
+	if actual := CombineWeighted(holders[:1]); !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	// and so are three light ones
+	if actual := CombineWeighted(holders[1:]); !bytes.Equal(actual, secret) {
+		t.Errorf("Was %v, but expected %v", actual, secret)
+	}
+
+	// but not two
+	if actual := CombineWeighted(holders[1:3]); bytes.Equal(actual, secret) {
+		t.Error("Two light holders shouldn't recover the secret")
+	}
+}
+
+func TestWeightedInvalidParameters(t *testing.T) {
+	cases := []struct {
+		weights []byte
+		k       byte
+		err     error
+	}{
+		{[]byte{1, 2}, 1, ErrInvalidThreshold},
+		{[]byte{1, 0}, 2, ErrInvalidWeight},
+		{[]byte{200, 100}, 2, ErrTooManyShares},
+		{[]byte{1, 1}, 3, ErrThresholdTooLarge},
+	}
+
+	for _, c := range cases {
+		if _, err := SplitWeighted(c.weights, c.k, []byte("secret")); err != c.err {
+			t.Errorf("SplitWeighted(%v, %v) was %v, but expected %v", c.weights, c.k, err, c.err)
+		}
+	}
+}